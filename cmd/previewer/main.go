@@ -2,8 +2,12 @@ package main
 
 import (
 	"context"
+	"errors"
+	"expvar"
 	"flag"
+	"fmt"
 	"log"
+	"os"
 	"os/signal"
 	"sync"
 	"syscall"
@@ -12,12 +16,23 @@ import (
 	internalApp "github.com/spendmail/s3_previewer/internal/app"
 	internalCache "github.com/spendmail/s3_previewer/internal/cache"
 	internalConfig "github.com/spendmail/s3_previewer/internal/config"
+	internalHealth "github.com/spendmail/s3_previewer/internal/health"
 	internalLogger "github.com/spendmail/s3_previewer/internal/logger"
+	internalMetrics "github.com/spendmail/s3_previewer/internal/metrics"
 	internalResizer "github.com/spendmail/s3_previewer/internal/resizer"
 	internalS3 "github.com/spendmail/s3_previewer/internal/s3"
 	internalServer "github.com/spendmail/s3_previewer/internal/server/http"
+	internalTracing "github.com/spendmail/s3_previewer/internal/tracing"
+	internalWatcher "github.com/spendmail/s3_previewer/internal/watcher"
 )
 
+const (
+	cacheBackendDisk  = "disk"
+	cacheBackendRedis = "redis"
+)
+
+var ErrUnknownCacheBackend = errors.New("unknown cache backend")
+
 var configPath string
 
 func init() {
@@ -41,23 +56,75 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer logger.Close()
 
-	cache, err := internalCache.New(config, logger)
+	// External rotation moves log files away and sends SIGUSR1 for them to be reopened.
+	reopen := make(chan os.Signal, 1)
+	signal.Notify(reopen, syscall.SIGUSR1)
+	go func() {
+		for range reopen {
+			if err := logger.Reopen(); err != nil {
+				logger.Error(err)
+			}
+		}
+	}()
+
+	tracing, err := internalTracing.New(config, logger)
 	if err != nil {
 		log.Fatal(err)
 	}
+	// Pending spans are exported after everything else is closed.
+	defer tracing.Close()
 
 	s3Client, err := internalS3.New(config, logger)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	app, err := internalApp.New(logger, internalResizer.New(), cache, s3Client)
+	health := internalHealth.New(config, logger, s3Client, version())
+
+	backend, closeBackend, err := newCacheBackend(config, logger)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer closeBackend()
+
+	switch backend := backend.(type) {
+	case *internalCache.LruCache:
+		health.Add("cache_restored", backend.CheckRestored)
+		health.Add("cache_writable", backend.CheckWritable)
+	case *internalCache.RedisCache:
+		health.Add("cache_redis", backend.Ping)
+	}
+
+	if config.GetCacheS3Bucket() != "" {
+		s3Cache, err := internalCache.NewS3(config, logger, s3Client)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		tieredCache := internalCache.NewTiered(logger, backend, s3Cache)
+		defer tieredCache.Close()
+		backend = tieredCache
+	}
+
+	var cache internalApp.Cache = backend
+	if config.GetCacheMemoryMaxBytes() > 0 {
+		cache = internalCache.NewMemory(config, logger, backend)
+	}
+
+	app, err := internalApp.New(config, logger, internalResizer.New(), cache, s3Client)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	server := internalServer.New(config, logger, app)
+	// Cache statistics are available at the admin vars endpoint and among metrics.
+	expvar.Publish("cache", expvar.Func(func() interface{} {
+		return app.CacheStats()
+	}))
+	internalMetrics.RegisterCache(app.CacheStats)
+
+	server := internalServer.New(config, logger, app, health)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -65,8 +132,33 @@ func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGHUP)
 	defer cancel()
 
+	// Variants of the prewarm list are rendered in background, while the server is already serving.
+	if file := config.GetWarmupFile(); file != "" {
+		job, err := app.StartWarmupFromFile(ctx, file)
+		if err != nil {
+			logger.Error(err.Error())
+		} else {
+			logger.Info(fmt.Sprintf("warm-up job %s of %s is started", job.ID, file))
+		}
+	}
+
 	var wg sync.WaitGroup
 
+	if len(config.GetWatcherPrefixes()) > 0 {
+		watcher, err := internalWatcher.New(config, logger, s3Client, app)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			logger.Info("starting prefix watcher...")
+			watcher.Run(ctx)
+		}()
+	}
+
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
@@ -97,3 +189,25 @@ func main() {
 
 	wg.Wait()
 }
+
+// newCacheBackend creates cache backend chosen by config, together with its close func.
+func newCacheBackend(config *internalConfig.Config, logger *internalLogger.Logger) (internalCache.Tier, func(), error) {
+	switch config.GetCacheBackend() {
+	case cacheBackendDisk, "":
+		cache, err := internalCache.New(config, logger)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return cache, cache.Close, nil
+	case cacheBackendRedis:
+		cache, err := internalCache.NewRedis(config, logger)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return cache, cache.Close, nil
+	default:
+		return nil, nil, fmt.Errorf("%w: %s", ErrUnknownCacheBackend, config.GetCacheBackend())
+	}
+}
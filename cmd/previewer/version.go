@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+
+	internalHealth "github.com/spendmail/s3_previewer/internal/health"
 )
 
 var (
@@ -12,16 +14,17 @@ var (
 	gitHash   = "UNKNOWN"
 )
 
-func printVersion() {
-	if err := json.NewEncoder(os.Stdout).Encode(struct {
-		Release   string
-		BuildDate string
-		GitHash   string
-	}{
+// version returns the build info baked in by the linker.
+func version() internalHealth.Version {
+	return internalHealth.Version{
 		Release:   release,
 		BuildDate: buildDate,
 		GitHash:   gitHash,
-	}); err != nil {
+	}
+}
+
+func printVersion() {
+	if err := json.NewEncoder(os.Stdout).Encode(version()); err != nil {
 		fmt.Printf("error while decode version info: %v\n", err)
 	}
 }
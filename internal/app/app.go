@@ -9,38 +9,126 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	internalCache "github.com/spendmail/s3_previewer/internal/cache"
+	internalConfig "github.com/spendmail/s3_previewer/internal/config"
+	internalMetrics "github.com/spendmail/s3_previewer/internal/metrics"
+	internalS3 "github.com/spendmail/s3_previewer/internal/s3"
+	internalTracing "github.com/spendmail/s3_previewer/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
 	DefaultScheme = "http://"
+	// Reasons of failures kept by negative cache items.
+	failureNotFound = "not-found"
+	failureDecode   = "decode"
+	// Warnings of stale images.
+	WarningStale              = `110 - "Response is Stale"`
+	WarningRevalidationFailed = `111 - "Revalidation Failed"`
+	// Cache statuses of delivered images.
+	CacheStatusHit         = "hit"
+	CacheStatusMiss        = "miss"
+	CacheStatusStale       = "stale"
+	CacheStatusRevalidated = "revalidated"
+	// Stale variants which source was unreachable are revalidated again in background after this interval.
+	revalidationRetryInterval = 5 * time.Second
 )
 
+type Config interface {
+	GetCacheMaxAge() time.Duration
+	GetCacheTTL() time.Duration
+	GetCacheStaleWhileRevalidate() time.Duration
+	GetCacheStaleIfError() time.Duration
+	GetCacheBucketTTL(bucket string) time.Duration
+	GetCacheNegativeTTL() time.Duration
+	GetPresets() map[string]internalConfig.PresetConf
+	GetRedirectBucket() string
+	GetRedirectPrefix() string
+	GetRedirectMinBytes() int64
+	GetRedirectExpires() time.Duration
+	GetWarmupConcurrency() int
+	GetEventsPresets() []string
+}
+
 type Logger interface {
 	Debug(args ...interface{})
 	Info(args ...interface{})
 	Warn(args ...interface{})
 	Error(args ...interface{})
+	WarnContext(ctx context.Context, args ...interface{})
 }
 
 type Resizer interface {
-	Resize(width, height uint, imageBytes []byte) ([]byte, error)
+	Resize(ctx context.Context, width, height uint, imageBytes []byte) ([]byte, error)
 }
 
 type Cache interface {
-	Set(key string, value []byte) error
-	Get(key string) ([]byte, error)
+	Set(key string, item *internalCache.Item) error
+	Get(key string) (*internalCache.Item, error)
+	Remove(key string)
+	PurgeObject(bucket, key string) int
+	PurgePrefix(bucket, prefix string) int
 	Clear()
+	Stats() internalCache.Stats
+}
+
+// ContextCache is a cache tracing its operations within the trace of the caller.
+type ContextCache interface {
+	GetContext(ctx context.Context, key string) (*internalCache.Item, error)
+	SetContext(ctx context.Context, key string, item *internalCache.Item) error
 }
 
 type S3Client interface {
-	Download(context context.Context, bucket, key string) ([]byte, error)
+	Download(ctx context.Context, bucket, key string) (*internalS3.Object, error)
+	DownloadIfChanged(ctx context.Context, bucket, key, etag string) (*internalS3.Object, error)
+	Head(ctx context.Context, bucket, key string) (*internalS3.Object, error)
+	Upload(ctx context.Context, bucket, key string, object *internalS3.Object) error
+	Remove(ctx context.Context, bucket, key string) error
+	Presign(ctx context.Context, bucket, key string, expires time.Duration) (string, error)
+	List(ctx context.Context, bucket, prefix string) ([]string, error)
 }
 
 type Application struct {
+	Config   Config
 	Logger   Logger
 	Resizer  Resizer
 	Cache    Cache
 	S3Client S3Client
+	warmup   warmup
+	// presetTTLs keeps cache lifetime overrides of presets by their sizes.
+	presetTTLs map[[2]int]time.Duration
+	// refreshing keeps cache keys of variants revalidated in background.
+	refreshing sync.Map
+	// refreshes tracks revalidations running in background.
+	refreshes sync.WaitGroup
+	// unreachable keeps times of the next revalidation attempt by cache keys of variants which source was unreachable.
+	unreachable sync.Map
+	// prunedAt keeps the time in nanoseconds unreachable marks were pruned at last time.
+	prunedAt int64
+}
+
+// jobsKey is a context key of the background jobs context.
+type jobsKey struct{}
+
+// Image is a resized image together with its validators.
+// Large images are stored in the redirect bucket, and RedirectURL is set instead of Bytes.
+type Image struct {
+	Bytes        []byte
+	ContentType  string
+	ETag         string
+	LastModified time.Time
+	RedirectURL  string
+	// Warning is set when a stale image is served.
+	Warning string
+	// CacheStatus tells how the image is obtained, one of CacheStatus constants.
+	CacheStatus string
 }
 
 var (
@@ -49,45 +137,631 @@ var (
 	ErrServerNotExists = errors.New("remove server doesn't exist")
 	ErrRequest         = errors.New("request error")
 	ErrFileRead        = errors.New("unable to read a file")
+	ErrRedirect        = errors.New("unable to redirect to a stored image")
+	ErrImageDecode     = errors.New("unable to decode an image")
+	ErrImageCached     = errors.New("image is cached")
 )
 
+// failures maps reasons of negative cache items to errors.
+var failures = map[string]error{
+	failureNotFound: ErrFileNotFound,
+	failureDecode:   ErrImageDecode,
+}
+
 // New is an application constructor.
-func New(logger Logger, resizer Resizer, cache Cache, s3Client S3Client) (*Application, error) {
+func New(config Config, logger Logger, resizer Resizer, cache Cache, s3Client S3Client) (*Application, error) {
 	return &Application{
-		Cache:    cache,
-		Logger:   logger,
-		Resizer:  resizer,
-		S3Client: s3Client,
+		Config:     config,
+		Cache:      cache,
+		Logger:     logger,
+		Resizer:    resizer,
+		S3Client:   s3Client,
+		presetTTLs: buildPresetTTLs(config.GetPresets()),
 	}, nil
 }
 
+// buildPresetTTLs indexes lifetime overrides of presets by their sizes.
+// If several presets have the same sizes, the first one by name wins.
+func buildPresetTTLs(presets map[string]internalConfig.PresetConf) map[[2]int]time.Duration {
+	names := make([]string, 0, len(presets))
+	for name, preset := range presets {
+		if preset.TTL > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	ttls := make(map[[2]int]time.Duration, len(names))
+	for _, name := range names {
+		size := [2]int{presets[name].Width, presets[name].Height}
+		if _, exists := ttls[size]; !exists {
+			ttls[size] = presets[name].TTL
+		}
+	}
+
+	return ttls
+}
+
 // ResizeImageByURL downloads, caches and crops images by given sizes and URL.
-func (app *Application) ResizeImageByURL(width, height int, bucket string, key string, headers map[string][]string) ([]byte, error) {
-	// Key includes sizes in order to store different files for different sizes of the same file.
-	hash := md5.Sum([]byte(fmt.Sprintf("%s-%s-%d-%d", bucket, key, width, height)))
-	cacheKey := hex.EncodeToString(hash[:])
+func (app *Application) ResizeImageByURL(ctx context.Context, width, height int, bucket string, key string, headers map[string][]string) (image *Image, err error) {
+	ctx, span := internalTracing.Tracer("app").Start(ctx, "app.ResizeImageByURL", trace.WithAttributes(
+		attribute.Int("image.width", width),
+		attribute.Int("image.height", height),
+		attribute.String("s3.bucket", bucket),
+		attribute.String("s3.key", key),
+	))
+	defer func() {
+		internalTracing.End(span, err)
+	}()
+
+	cacheKey := buildCacheKey(width, height, bucket, key)
+
+	// If file exists in cache, return from there, revalidating it against the source when it is stale.
+	item, err := app.getCached(ctx, cacheKey)
+	if err == nil {
+		switch {
+		case item.Failure != "":
+			return nil, failureError(item)
+		case app.isFresh(item):
+			return app.deliver(ctx, item, width, height, CacheStatusHit)
+		case app.isRevalidatedInBackground(item):
+			app.refresh(ctx, cacheKey, item, width, height, bucket, key)
+			return app.deliverStale(ctx, item, width, height, WarningStale)
+		case app.isUnreachable(cacheKey) && app.isServedOnError(item):
+			// Requests don't wait for the unreachable source, it's retried in background once in a while.
+			if app.isRetryDue(cacheKey) {
+				app.refresh(ctx, cacheKey, item, width, height, bucket, key)
+			}
+			return app.deliverStale(ctx, item, width, height, WarningRevalidationFailed)
+		}
+
+		return app.revalidate(ctx, cacheKey, item, width, height, bucket, key)
+	}
+
+	object, err := app.download(ctx, bucket, key, "")
+	if err != nil {
+		return nil, app.fail(ctx, cacheKey, width, height, bucket, key, wrapS3Error(err))
+	}
+
+	return app.render(ctx, cacheKey, object, width, height, bucket, key)
+}
+
+// GetImageInfo returns validators of a variant which isn't cached, using source object metadata only,
+// so that conditional requests of such variants are answered without downloading and resizing the source.
+// Cached variants are delivered or revalidated by ResizeImageByURL in a single round trip, so ErrImageCached
+// is returned for them.
+func (app *Application) GetImageInfo(ctx context.Context, width, height int, bucket string, key string) (image *Image, err error) {
+	ctx, span := internalTracing.Tracer("app").Start(ctx, "app.GetImageInfo", trace.WithAttributes(
+		attribute.Int("image.width", width),
+		attribute.Int("image.height", height),
+		attribute.String("s3.bucket", bucket),
+		attribute.String("s3.key", key),
+	))
+	defer func() {
+		internalTracing.End(span, err)
+	}()
+
+	item, err := app.getCached(ctx, buildCacheKey(width, height, bucket, key))
+	if err == nil && item.Failure != "" {
+		return nil, failureError(item)
+	}
 
-	// If file exists in cache, return from there.
-	resultBytes, err := app.Cache.Get(cacheKey)
 	if err == nil {
-		return resultBytes, nil
+		return nil, ErrImageCached
 	}
 
-	sourceBytes, err := app.S3Client.Download(context.TODO(), bucket, key)
+	object, err := app.S3Client.Head(ctx, bucket, key)
 	if err != nil {
-		return []byte{}, err
+		return nil, wrapS3Error(err)
+	}
+
+	return &Image{
+		ETag:         buildETag(object.ETag, width, height),
+		LastModified: object.LastModified,
+		CacheStatus:  CacheStatusMiss,
+	}, nil
+}
+
+// revalidate checks whether the source of a cached item has changed since the item was rendered.
+// Changed sources are rendered again, deleted ones are replaced by negative items.
+func (app *Application) revalidate(ctx context.Context, cacheKey string, item *internalCache.Item, width, height int, bucket, key string) (*Image, error) {
+	// Variants which lifetime is over are rendered again, even if their sources haven't changed.
+	etag := item.SourceETag
+	if isOutlived(item) {
+		etag = ""
+	}
+
+	object, err := app.download(ctx, bucket, key, etag)
+	if err == nil || errors.Is(err, internalS3.ErrObjectNotModified) || errors.Is(err, internalS3.ErrObjectNotFound) {
+		app.unreachable.Delete(cacheKey)
+	}
+
+	switch {
+	case errors.Is(err, internalS3.ErrObjectNotModified):
+		item.ValidatedAt = time.Now()
+
+		// Only metadata is updated, the stored file stays untouched.
+		meta := *item
+		meta.Value = nil
+		app.setCached(ctx, cacheKey, &meta)
+
+		return app.deliver(ctx, item, width, height, CacheStatusRevalidated)
+	case errors.Is(err, internalS3.ErrObjectNotFound):
+		return nil, app.fail(ctx, cacheKey, width, height, bucket, key, wrapS3Error(err))
+	case err != nil && !app.isServedOnError(item):
+		return nil, wrapS3Error(err)
+	case err != nil:
+		// Source is unreachable, so serving a stale copy is better than failing.
+		app.Logger.WarnContext(ctx, err)
+		app.markUnreachable(cacheKey)
+
+		return app.deliverStale(ctx, item, width, height, WarningRevalidationFailed)
+	}
+
+	return app.render(ctx, cacheKey, object, width, height, bucket, key)
+}
+
+// getCached reads the item from cache within a span.
+func (app *Application) getCached(ctx context.Context, cacheKey string) (*internalCache.Item, error) {
+	ctx, span := internalTracing.Tracer("app").Start(ctx, "cache.Get", trace.WithAttributes(attribute.String("cache.key", cacheKey)))
+	defer span.End()
+
+	var item *internalCache.Item
+	var err error
+	if cache, ok := app.Cache.(ContextCache); ok {
+		item, err = cache.GetContext(ctx, cacheKey)
+	} else {
+		item, err = app.Cache.Get(cacheKey)
+	}
+	span.SetAttributes(attribute.Bool("cache.hit", err == nil))
+
+	return item, err
+}
+
+// setCached stores the item in cache within a span. Caching is best-effort, so errors are only recorded.
+func (app *Application) setCached(ctx context.Context, cacheKey string, item *internalCache.Item) {
+	ctx, span := internalTracing.Tracer("app").Start(ctx, "cache.Set", trace.WithAttributes(
+		attribute.String("cache.key", cacheKey),
+		attribute.Int("cache.bytes", len(item.Value)),
+	))
+
+	if cache, ok := app.Cache.(ContextCache); ok {
+		internalTracing.End(span, cache.SetContext(ctx, cacheKey, item))
+		return
+	}
+
+	internalTracing.End(span, app.Cache.Set(cacheKey, item))
+}
+
+// download fetches the source object unless its ETag equals the given one, recording metrics of the download.
+func (app *Application) download(ctx context.Context, bucket, key, etag string) (*internalS3.Object, error) {
+	start := time.Now()
+	object, err := app.S3Client.DownloadIfChanged(ctx, bucket, key, etag)
+
+	bytes := 0
+	if object != nil {
+		bytes = len(object.Body)
+	}
+	internalMetrics.ObserveS3Download(downloadResult(err), bytes, time.Since(start))
+
+	return object, err
+}
+
+// ContextWithJobs returns a copy of ctx carrying the context of background jobs, which outlive the request,
+// so that revalidations started by the request are cancelled together with other jobs.
+func ContextWithJobs(ctx, jobs context.Context) context.Context {
+	return context.WithValue(ctx, jobsKey{}, jobs)
+}
+
+// jobsContext returns the context of background jobs carried by ctx, or the background context.
+func jobsContext(ctx context.Context) context.Context {
+	if jobs, ok := ctx.Value(jobsKey{}).(context.Context); ok {
+		return jobs
 	}
 
-	resultBytes, err = app.Resizer.Resize(uint(width), uint(height), sourceBytes)
+	return context.Background()
+}
+
+// refresh revalidates the item in background, once at a time for every variant.
+func (app *Application) refresh(ctx context.Context, cacheKey string, item *internalCache.Item, width, height int, bucket, key string) {
+	if _, running := app.refreshing.LoadOrStore(cacheKey, struct{}{}); running {
+		return
+	}
+
+	// Revalidation updates the item, while the caller is delivering it.
+	stale := *item
+	jobs := jobsContext(ctx)
+
+	app.refreshes.Add(1)
+	go func() {
+		defer app.refreshes.Done()
+		defer app.refreshing.Delete(cacheKey)
+
+		if _, err := app.revalidate(jobs, cacheKey, &stale, width, height, bucket, key); err != nil {
+			app.Logger.Warn(err)
+		}
+	}()
+}
+
+// Wait waits for revalidations running in background to finish.
+func (app *Application) Wait() {
+	app.refreshes.Wait()
+}
+
+// render resizes a source object and puts the result in cache.
+// Large results are stored in the redirect bucket, and only their location is cached.
+func (app *Application) render(ctx context.Context, cacheKey string, object *internalS3.Object, width, height int, bucket, key string) (*Image, error) {
+	resultBytes, err := app.Resizer.Resize(ctx, uint(width), uint(height), object.Body)
 	if err != nil {
-		return []byte{}, err
+		return nil, app.fail(ctx, cacheKey, width, height, bucket, key, fmt.Errorf("%w: %s", ErrImageDecode, err))
+	}
+
+	item := &internalCache.Item{
+		Value:        resultBytes,
+		Bucket:       bucket,
+		Key:          key,
+		ContentType:  http.DetectContentType(resultBytes),
+		SourceETag:   object.ETag,
+		LastModified: object.LastModified,
+		ValidatedAt:  time.Now(),
+	}
+
+	app.setLifetime(item, width, height, bucket)
+
+	// Image of the previous variant could be stored in the redirect bucket, while the new one is cached itself.
+	if !app.isRedirected(resultBytes) {
+		app.removeLocation(ctx, width, height, bucket, key)
+	} else if err := app.store(ctx, item, width, height); err != nil {
+		// Proxying the image is better than failing.
+		app.Logger.WarnContext(ctx, err)
+		app.removeLocation(ctx, width, height, bucket, key)
 	}
 
 	// Set processed image in cache
-	_ = app.Cache.Set(cacheKey, resultBytes)
+	app.setCached(ctx, cacheKey, item)
+
+	// And return image with validators.
+	return app.deliver(ctx, item, width, height, CacheStatusMiss)
+}
+
+// fail caches the failure of a missing or broken source, so that its requests don't reach s3 for a while.
+// Other errors are returned as is.
+func (app *Application) fail(ctx context.Context, cacheKey string, width, height int, bucket, key string, err error) error {
+	ttl := app.Config.GetCacheNegativeTTL()
+
+	var failure string
+	switch {
+	case errors.Is(err, ErrFileNotFound):
+		failure = failureNotFound
+	case errors.Is(err, ErrImageDecode):
+		failure = failureDecode
+	default:
+		return err
+	}
+
+	if ttl <= 0 {
+		// Variants of deleted sources are evicted anyway.
+		if failure == failureNotFound {
+			app.removeLocation(ctx, width, height, bucket, key)
+			app.Cache.Remove(cacheKey)
+		}
+		return err
+	}
+
+	// Negative item replaces the variant, so its stored image isn't needed anymore.
+	app.removeLocation(ctx, width, height, bucket, key)
+
+	_ = app.Cache.Set(cacheKey, &internalCache.Item{
+		Value:     []byte{},
+		Bucket:    bucket,
+		Key:       key,
+		Failure:   failure,
+		ExpiresAt: time.Now().Add(ttl),
+	})
+
+	return err
+}
+
+// failureError converts the reason of negative item into error.
+func failureError(item *internalCache.Item) error {
+	err, exists := failures[item.Failure]
+	if !exists {
+		err = ErrDownload
+	}
+
+	return fmt.Errorf("%w: %s/%s is cached as failed", err, item.Bucket, item.Key)
+}
+
+// isRedirected checks whether image is large enough to be delivered by a redirect.
+func (app *Application) isRedirected(bytes []byte) bool {
+	return app.Config.GetRedirectBucket() != "" && int64(len(bytes)) >= app.Config.GetRedirectMinBytes()
+}
+
+// store uploads image to the redirect bucket, so that item keeps its location instead of the value.
+func (app *Application) store(ctx context.Context, item *internalCache.Item, width, height int) error {
+	location := app.variantLocation(width, height, item.Bucket, item.Key)
+
+	err := app.S3Client.Upload(ctx, app.Config.GetRedirectBucket(), location, &internalS3.Object{
+		Body:        item.Value,
+		ContentType: item.ContentType,
+		Metadata:    map[string]string{"source-etag": item.SourceETag},
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrRedirect, err)
+	}
+
+	item.Value = []byte{}
+	item.Location = location
+
+	return nil
+}
+
+// deliver builds image from cached item: either its value, or a presigned url of the stored image.
+func (app *Application) deliver(ctx context.Context, item *internalCache.Item, width, height int, cacheStatus string) (*Image, error) {
+	image := newImage(item.Value, item, width, height)
+	image.CacheStatus = cacheStatus
+	if item.Location == "" {
+		return image, nil
+	}
+
+	url, err := app.S3Client.Presign(ctx, app.Config.GetRedirectBucket(), item.Location, app.Config.GetRedirectExpires())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrRedirect, err)
+	}
+
+	image.Bytes = nil
+	image.RedirectURL = url
+
+	return image, nil
+}
+
+// deliverStale builds image from the stale item, warning about it.
+func (app *Application) deliverStale(ctx context.Context, item *internalCache.Item, width, height int, warning string) (*Image, error) {
+	image, err := app.deliver(ctx, item, width, height, CacheStatusStale)
+	if err != nil {
+		return nil, err
+	}
+
+	image.Warning = warning
+
+	return image, nil
+}
+
+// setLifetime sets expiration of the item. Items which could be served stale when refreshing fails
+// are kept in cache longer than their lifetime. Otherwise zero expiration means that the cache default is used.
+func (app *Application) setLifetime(item *internalCache.Item, width, height int, bucket string) {
+	ttl := app.ttl(width, height, bucket)
+	grace := app.Config.GetCacheStaleIfError()
+
+	if ttl <= 0 && grace > 0 {
+		ttl = app.Config.GetCacheTTL()
+	}
+
+	if ttl <= 0 {
+		return
+	}
+
+	item.ExpiresAt = time.Now().Add(ttl)
+
+	if grace > 0 {
+		item.StaleAt = item.ExpiresAt
+		item.ExpiresAt = item.StaleAt.Add(grace)
+	}
+}
+
+// ttl returns cache lifetime override of a variant: a preset with the same sizes wins over the bucket setting.
+// Zero means that the cache default is used.
+func (app *Application) ttl(width, height int, bucket string) time.Duration {
+	if ttl, exists := app.presetTTLs[[2]int{width, height}]; exists {
+		return ttl
+	}
+
+	return app.Config.GetCacheBucketTTL(bucket)
+}
+
+// isFresh checks whether cached item was validated against its source recently enough, and its lifetime isn't over.
+func (app *Application) isFresh(item *internalCache.Item) bool {
+	return item.SourceETag != "" && time.Now().Before(app.staleSince(item))
+}
+
+// isRevalidatedInBackground checks whether stale item could be served while it's refreshed.
+func (app *Application) isRevalidatedInBackground(item *internalCache.Item) bool {
+	window := app.Config.GetCacheStaleWhileRevalidate()
+
+	return window > 0 && item.SourceETag != "" && time.Now().Before(app.staleSince(item).Add(window))
+}
+
+// staleSince returns the time item becomes stale at: either it has to be revalidated, or its lifetime is over.
+func (app *Application) staleSince(item *internalCache.Item) time.Time {
+	since := item.ValidatedAt.Add(app.Config.GetCacheMaxAge())
+	if !item.StaleAt.IsZero() && item.StaleAt.Before(since) {
+		since = item.StaleAt
+	}
+
+	return since
+}
+
+// isUnreachable checks whether the source of the variant was unreachable when it was revalidated last time.
+func (app *Application) isUnreachable(cacheKey string) bool {
+	_, unreachable := app.unreachable.Load(cacheKey)
+	return unreachable
+}
+
+// isServedOnError checks whether stale item could be served when its source is unreachable,
+// that is whether it's stale for no longer than the stale-if-error window.
+func (app *Application) isServedOnError(item *internalCache.Item) bool {
+	grace := app.Config.GetCacheStaleIfError()
+
+	return grace > 0 && time.Now().Before(app.staleSince(item).Add(grace))
+}
+
+// markUnreachable postpones revalidation of the variant which source is unreachable. Once in a while marks
+// of variants which weren't requested during the stale-if-error window are pruned, as they couldn't be served anymore.
+func (app *Application) markUnreachable(cacheKey string) {
+	now := time.Now()
+	app.unreachable.Store(cacheKey, now.Add(revalidationRetryInterval))
+
+	prunedAt := atomic.LoadInt64(&app.prunedAt)
+	if now.UnixNano()-prunedAt < int64(revalidationRetryInterval) ||
+		!atomic.CompareAndSwapInt64(&app.prunedAt, prunedAt, now.UnixNano()) {
+		return
+	}
+
+	horizon := now.Add(-app.Config.GetCacheStaleIfError())
+	app.unreachable.Range(func(cacheKey, retryAt interface{}) bool {
+		if retryAt.(time.Time).Before(horizon) {
+			app.unreachable.Delete(cacheKey)
+		}
+
+		return true
+	})
+}
+
+// isRetryDue checks whether revalidation of the variant with unreachable source could be retried,
+// postponing the next attempt if so.
+func (app *Application) isRetryDue(cacheKey string) bool {
+	retryAt, exists := app.unreachable.Load(cacheKey)
+	if !exists || time.Now().Before(retryAt.(time.Time)) {
+		return false
+	}
+
+	app.unreachable.Store(cacheKey, time.Now().Add(revalidationRetryInterval))
+
+	return true
+}
+
+// isOutlived checks whether item is kept in cache after its lifetime to be served if refreshing fails.
+func isOutlived(item *internalCache.Item) bool {
+	return !item.StaleAt.IsZero() && !time.Now().Before(item.StaleAt)
+}
+
+// newImage builds image from cached item metadata.
+func newImage(bytes []byte, item *internalCache.Item, width, height int) *Image {
+	image := &Image{
+		Bytes:        bytes,
+		ContentType:  item.ContentType,
+		LastModified: item.LastModified,
+	}
+
+	if item.SourceETag != "" {
+		image.ETag = buildETag(item.SourceETag, width, height)
+	}
+
+	return image
+}
+
+// sourceLocation returns the prefix variants of the source object are stored under in the redirect bucket,
+// so that they could be found by source object or by its key prefix.
+func (app *Application) sourceLocation(bucket, key string) string {
+	return app.Config.GetRedirectPrefix() + bucket + "/" + key + "/"
+}
+
+// variantLocation returns a key of the variant stored in the redirect bucket.
+func (app *Application) variantLocation(width, height int, bucket, key string) string {
+	return app.sourceLocation(bucket, key) + fmt.Sprintf("%dx%d", width, height)
+}
+
+// removeLocation removes image of the variant from the redirect bucket. Its location is derived from the variant
+// rather than looked up in cache, so that images of expired and evicted variants are removed as well.
+func (app *Application) removeLocation(ctx context.Context, width, height int, bucket, key string) {
+	redirectBucket := app.Config.GetRedirectBucket()
+	if redirectBucket == "" {
+		return
+	}
+
+	if err := app.S3Client.Remove(ctx, redirectBucket, app.variantLocation(width, height, bucket, key)); err != nil {
+		app.Logger.WarnContext(ctx, fmt.Errorf("%w: %s", ErrRedirect, err))
+	}
+}
+
+// removeLocations removes images stored in the redirect bucket under the prefix, which locations match the filter.
+func (app *Application) removeLocations(ctx context.Context, prefix string, match func(location string) bool) {
+	bucket := app.Config.GetRedirectBucket()
+	if bucket == "" {
+		return
+	}
+
+	locations, err := app.S3Client.List(ctx, bucket, prefix)
+	if err != nil {
+		app.Logger.WarnContext(ctx, fmt.Errorf("%w: %s", ErrRedirect, err))
+		return
+	}
+
+	for _, location := range locations {
+		if !match(location) {
+			continue
+		}
+
+		if err := app.S3Client.Remove(ctx, bucket, location); err != nil {
+			app.Logger.WarnContext(ctx, fmt.Errorf("%w: %s", ErrRedirect, err))
+		}
+	}
+}
+
+// PurgeObject removes all cached variants of the source object, together with the ones stored in the redirect bucket.
+func (app *Application) PurgeObject(ctx context.Context, bucket, key string) int {
+	purged := app.Cache.PurgeObject(bucket, key)
+
+	prefix := app.sourceLocation(bucket, key)
+	app.removeLocations(ctx, prefix, func(location string) bool {
+		// Variants of objects nested under the key are kept.
+		return !strings.Contains(strings.TrimPrefix(location, prefix), "/")
+	})
+
+	return purged
+}
+
+// PurgePrefix removes all cached variants of source objects which keys start with the prefix,
+// together with the ones stored in the redirect bucket.
+func (app *Application) PurgePrefix(ctx context.Context, bucket, prefix string) int {
+	purged := app.Cache.PurgePrefix(bucket, prefix)
+
+	app.removeLocations(ctx, app.Config.GetRedirectPrefix()+bucket+"/"+prefix, func(location string) bool {
+		return true
+	})
+
+	return purged
+}
+
+// CacheStats returns cache statistics.
+func (app *Application) CacheStats() internalCache.Stats {
+	return app.Cache.Stats()
+}
+
+// buildCacheKey generates cache key.
+// Key includes sizes in order to store different files for different sizes of the same file.
+func buildCacheKey(width, height int, bucket, key string) string {
+	hash := md5.Sum([]byte(fmt.Sprintf("%s-%s-%d-%d", bucket, key, width, height)))
+	return hex.EncodeToString(hash[:])
+}
+
+// buildETag generates a strong entity tag from the source object ETag and transformation parameters.
+func buildETag(sourceETag string, width, height int) string {
+	hash := md5.Sum([]byte(fmt.Sprintf("%s-%d-%d", sourceETag, width, height)))
+	return `"` + hex.EncodeToString(hash[:]) + `"`
+}
+
+// downloadResult describes the download error for metrics.
+func downloadResult(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, internalS3.ErrObjectNotModified):
+		return "not_modified"
+	case errors.Is(err, internalS3.ErrObjectNotFound):
+		return "not_found"
+	default:
+		return "error"
+	}
+}
+
+// wrapS3Error converts s3 client errors into application errors.
+func wrapS3Error(err error) error {
+	if errors.Is(err, internalS3.ErrObjectNotFound) {
+		return fmt.Errorf("%w: %s", ErrFileNotFound, err)
+	}
 
-	// And return slice of bytes.
-	return resultBytes, nil
+	return fmt.Errorf("%w: %s", ErrDownload, err)
 }
 
 // downloadByURL downloads image by given url forwarding original headers.
@@ -2,89 +2,778 @@ package app
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"image"
-	_ "image/jpeg"
+	"image/color"
+	"image/jpeg"
 	"net/http"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	internalcache "github.com/spendmail/s3_previewer/internal/cache"
 	internalconfig "github.com/spendmail/s3_previewer/internal/config"
-	internallogger "github.com/spendmail/s3_previewer/internal/logger"
 	internalresizer "github.com/spendmail/s3_previewer/internal/resizer"
+	internals3 "github.com/spendmail/s3_previewer/internal/s3"
 	"github.com/stretchr/testify/require"
 )
 
 var (
 	ImageWidth           = 300
 	ImageHeight          = 200
-	ImageURL             = "raw.githubusercontent.com/OtusGolang/final_project/master/examples/image-previewer/gopher_2000x1000.jpg"
-	WrongImageURLPath    = "raw.githubusercontent.com/mistake_in_the_path"
-	WrongDNSURL          = "this-is-non-existent-domain.com/image.jpeg"
+	WrongDNSURL          = "this-is-non-existent-domain.invalid/image.jpeg"
 	ContentTypeImageJpeg = "image/jpeg"
 )
 
-func TestApplication(t *testing.T) {
-	t.Run("succeeding test", func(t *testing.T) {
-		config, err := internalconfig.NewConfig("../../configs/previewer.toml")
-		require.NoError(t, err, "should be without errors")
+type fakeLogger struct{}
 
-		logger, err := internallogger.New(config)
-		require.NoError(t, err, "should be without errors")
+func (fakeLogger) Debug(args ...interface{})                            {}
+func (fakeLogger) Info(args ...interface{})                             {}
+func (fakeLogger) Warn(args ...interface{})                             {}
+func (fakeLogger) Error(args ...interface{})                            {}
+func (fakeLogger) WarnContext(ctx context.Context, args ...interface{}) {}
 
-		cache, err := internalcache.New(config, logger)
-		require.NoError(t, err, "should be without errors")
+// fakeS3Client keeps objects in memory by "bucket/key". Every request fails with err when it is set.
+type fakeS3Client struct {
+	mutex     sync.Mutex
+	objects   map[string]*internals3.Object
+	err       error
+	downloads int
+	heads     int
+	uploads   []string
+	removed   []string
+}
+
+func newFakeS3Client() *fakeS3Client {
+	return &fakeS3Client{objects: make(map[string]*internals3.Object)}
+}
+
+func (c *fakeS3Client) put(bucket, key string, body []byte, etag string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.objects[bucket+"/"+key] = &internals3.Object{Body: body, ETag: etag, LastModified: time.Now().Truncate(time.Second)}
+}
+
+func (c *fakeS3Client) setErr(err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.err = err
+}
+
+func (c *fakeS3Client) downloadCount() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.downloads
+}
+
+func (c *fakeS3Client) Download(ctx context.Context, bucket, key string) (*internals3.Object, error) {
+	return c.DownloadIfChanged(ctx, bucket, key, "")
+}
+
+func (c *fakeS3Client) DownloadIfChanged(ctx context.Context, bucket, key, etag string) (*internals3.Object, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.downloads++
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	object, exists := c.objects[bucket+"/"+key]
+	switch {
+	case !exists:
+		return nil, internals3.ErrObjectNotFound
+	case etag != "" && object.ETag == etag:
+		return nil, internals3.ErrObjectNotModified
+	}
+
+	copied := *object
+
+	return &copied, nil
+}
+
+func (c *fakeS3Client) Head(ctx context.Context, bucket, key string) (*internals3.Object, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.heads++
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	object, exists := c.objects[bucket+"/"+key]
+	if !exists {
+		return nil, internals3.ErrObjectNotFound
+	}
+
+	return &internals3.Object{ETag: object.ETag, LastModified: object.LastModified}, nil
+}
+
+func (c *fakeS3Client) Upload(ctx context.Context, bucket, key string, object *internals3.Object) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.err != nil {
+		return c.err
+	}
+
+	c.objects[bucket+"/"+key] = object
+	c.uploads = append(c.uploads, bucket+"/"+key)
+
+	return nil
+}
+
+func (c *fakeS3Client) Remove(ctx context.Context, bucket, key string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.objects, bucket+"/"+key)
+	c.removed = append(c.removed, bucket+"/"+key)
+
+	return nil
+}
+
+func (c *fakeS3Client) Presign(ctx context.Context, bucket, key string, expires time.Duration) (string, error) {
+	return "https://s3.example.com/" + bucket + "/" + key, nil
+}
+
+func (c *fakeS3Client) List(ctx context.Context, bucket, prefix string) ([]string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	var keys []string
+	for name := range c.objects {
+		if key := strings.TrimPrefix(name, bucket+"/"); key != name && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// fakeResizer prefixes source bytes instead of resizing them.
+type fakeResizer struct {
+	mutex sync.Mutex
+	err   error
+	calls int
+}
+
+func (r *fakeResizer) Resize(ctx context.Context, width, height uint, imageBytes []byte) ([]byte, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.calls++
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	return append([]byte(fmt.Sprintf("%dx%d:", width, height)), imageBytes...), nil
+}
 
-		app, err := New(logger, internalresizer.New(), cache)
+func (r *fakeResizer) callCount() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.calls
+}
+
+// fakeCache keeps items in memory, expiring them like the real cache does.
+type fakeCache struct {
+	mutex sync.Mutex
+	items map[string]internalcache.Item
+}
+
+func newFakeCache() *fakeCache {
+	return &fakeCache{items: make(map[string]internalcache.Item)}
+}
+
+func (c *fakeCache) Set(key string, item *internalcache.Item) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	stored := *item
+	if item.Value == nil {
+		existing, exists := c.items[key]
+		if !exists {
+			return nil
+		}
+		stored.Value = existing.Value
+	}
+	c.items[key] = stored
+
+	return nil
+}
+
+func (c *fakeCache) Get(key string) (*internalcache.Item, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	item, exists := c.items[key]
+	if !exists {
+		return nil, internalcache.ErrItemNotExists
+	}
+
+	if item.IsExpired(time.Now()) {
+		delete(c.items, key)
+		return nil, internalcache.ErrItemExpired
+	}
+
+	return &item, nil
+}
+
+func (c *fakeCache) Remove(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.items, key)
+}
+
+func (c *fakeCache) PurgeObject(bucket, key string) int {
+	return c.purge(func(item internalcache.Item) bool {
+		return item.Bucket == bucket && item.Key == key
+	})
+}
+
+func (c *fakeCache) PurgePrefix(bucket, prefix string) int {
+	return c.purge(func(item internalcache.Item) bool {
+		return item.Bucket == bucket && strings.HasPrefix(item.Key, prefix)
+	})
+}
+
+func (c *fakeCache) purge(match func(item internalcache.Item) bool) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	purged := 0
+	for key, item := range c.items {
+		if match(item) {
+			delete(c.items, key)
+			purged++
+		}
+	}
+
+	return purged
+}
+
+func (c *fakeCache) Clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.items = make(map[string]internalcache.Item)
+}
+
+func (c *fakeCache) Stats() internalcache.Stats {
+	return internalcache.Stats{}
+}
+
+func (c *fakeCache) get(key string) (internalcache.Item, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	item, exists := c.items[key]
+
+	return item, exists
+}
+
+// newJpeg encodes a plain image of the given sizes.
+func newJpeg(t *testing.T, width, height int) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 100, A: 255})
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, jpeg.Encode(buf, img, nil))
+
+	return buf.Bytes()
+}
+
+func TestApplication(t *testing.T) {
+	t.Run("succeeding test", func(t *testing.T) {
+		s3Client := newFakeS3Client()
+		s3Client.put("images", "gopher.jpg", newJpeg(t, 600, 400), `"gopher"`)
+
+		config := &internalconfig.Config{Cache: internalconfig.CacheConf{MaxAge: time.Minute}}
+		app, err := New(config, fakeLogger{}, internalresizer.New(), newFakeCache(), s3Client)
 		require.NoError(t, err, "should be without errors")
 
-		headers := map[string][]string{}
-		imageBytes, err := app.ResizeImageByURL(ImageWidth, ImageHeight, ImageURL, headers)
+		resized, err := app.ResizeImageByURL(context.Background(), ImageWidth, ImageHeight, "images", "gopher.jpg", nil)
 		require.NoError(t, err, "should be without errors")
+		require.Equal(t, CacheStatusMiss, resized.CacheStatus)
 
-		bytesContentType := http.DetectContentType(imageBytes)
+		bytesContentType := http.DetectContentType(resized.Bytes)
 		require.Equal(t, ContentTypeImageJpeg, bytesContentType, fmt.Sprintf("content type should be %s, but %s given", ContentTypeImageJpeg, bytesContentType))
 
-		img, _, err := image.DecodeConfig(bytes.NewReader(imageBytes))
+		img, _, err := image.DecodeConfig(bytes.NewReader(resized.Bytes))
 		require.NoError(t, err, "should be without errors")
 		require.Equal(t, ImageWidth, img.Width, fmt.Sprintf("image width should be %d, but %d given", ImageWidth, img.Width))
 		require.Equal(t, ImageHeight, img.Height, fmt.Sprintf("image height should be %d, but %d given", ImageHeight, img.Height))
+
+		// Fresh variant is served from cache.
+		cached, err := app.ResizeImageByURL(context.Background(), ImageWidth, ImageHeight, "images", "gopher.jpg", nil)
+		require.NoError(t, err)
+		require.Equal(t, CacheStatusHit, cached.CacheStatus)
+		require.Equal(t, resized.Bytes, cached.Bytes)
+		require.Equal(t, 1, s3Client.downloadCount())
 	})
 
 	t.Run("wrong dns", func(t *testing.T) {
-		config, err := internalconfig.NewConfig("../../configs/previewer.toml")
-		require.NoError(t, err, "should be without errors")
-
-		logger, err := internallogger.New(config)
-		require.NoError(t, err, "should be without errors")
-
-		cache, err := internalcache.New(config, logger)
-		require.NoError(t, err, "should be without errors")
-
-		app, err := New(logger, internalresizer.New(), cache)
+		app, err := New(&internalconfig.Config{}, fakeLogger{}, &fakeResizer{}, newFakeCache(), newFakeS3Client())
 		require.NoError(t, err, "should be without errors")
 
 		headers := map[string][]string{}
-		_, err = app.ResizeImageByURL(ImageWidth, ImageHeight, WrongDNSURL, headers)
+		_, err = app.downloadByURL(WrongDNSURL, headers)
 		require.Truef(t, errors.Is(err, ErrServerNotExists), "actual error %q", err)
 	})
 
 	t.Run("file not found", func(t *testing.T) {
-		config, err := internalconfig.NewConfig("../../configs/previewer.toml")
+		app, err := New(&internalconfig.Config{}, fakeLogger{}, &fakeResizer{}, newFakeCache(), newFakeS3Client())
 		require.NoError(t, err, "should be without errors")
 
-		logger, err := internallogger.New(config)
-		require.NoError(t, err, "should be without errors")
+		_, err = app.ResizeImageByURL(context.Background(), ImageWidth, ImageHeight, "images", "missing.jpg", nil)
+		require.Truef(t, errors.Is(err, ErrFileNotFound), "actual error %q", err)
+	})
+}
 
-		cache, err := internalcache.New(config, logger)
-		require.NoError(t, err, "should be without errors")
+func TestNegativeCaching(t *testing.T) {
+	tests := []struct {
+		name        string
+		negativeTTL time.Duration
+		prepare     func(s3Client *fakeS3Client, resizer *fakeResizer)
+		err         error
+		// Requests reaching s3 and resizer, when the variant is requested twice.
+		downloads, resizes int
+	}{
+		{
+			name:        "missing source",
+			negativeTTL: time.Minute,
+			prepare:     func(s3Client *fakeS3Client, resizer *fakeResizer) {},
+			err:         ErrFileNotFound,
+			downloads:   1,
+		},
+		{
+			name:        "broken source",
+			negativeTTL: time.Minute,
+			prepare: func(s3Client *fakeS3Client, resizer *fakeResizer) {
+				s3Client.put("images", "cat.jpg", []byte("not an image"), `"cat"`)
+				resizer.err = errors.New("image: unknown format")
+			},
+			err:       ErrImageDecode,
+			downloads: 1,
+			resizes:   1,
+		},
+		{
+			name:    "failures aren't cached without negative ttl",
+			prepare: func(s3Client *fakeS3Client, resizer *fakeResizer) {},
+			err:     ErrFileNotFound,
+			// Every request reaches s3.
+			downloads: 2,
+		},
+		{
+			name:        "s3 errors aren't cached",
+			negativeTTL: time.Minute,
+			prepare: func(s3Client *fakeS3Client, resizer *fakeResizer) {
+				s3Client.err = errors.New("connection reset")
+			},
+			err:       ErrDownload,
+			downloads: 2,
+		},
+	}
 
-		app, err := New(logger, internalresizer.New(), cache)
-		require.NoError(t, err, "should be without errors")
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			config := &internalconfig.Config{Cache: internalconfig.CacheConf{NegativeTTL: tc.negativeTTL}}
+			s3Client, resizer := newFakeS3Client(), &fakeResizer{}
+			tc.prepare(s3Client, resizer)
 
-		headers := map[string][]string{}
-		_, err = app.ResizeImageByURL(ImageWidth, ImageHeight, WrongImageURLPath, headers)
+			app, err := New(config, fakeLogger{}, resizer, newFakeCache(), s3Client)
+			require.NoError(t, err)
+
+			for i := 0; i < 2; i++ {
+				_, err = app.ResizeImageByURL(context.Background(), 100, 100, "images", "cat.jpg", nil)
+				require.Truef(t, errors.Is(err, tc.err), "actual error %q", err)
+			}
+
+			require.Equal(t, tc.downloads, s3Client.downloadCount())
+			require.Equal(t, tc.resizes, resizer.callCount())
+		})
+	}
+
+	t.Run("negative ttl expiry", func(t *testing.T) {
+		config := &internalconfig.Config{Cache: internalconfig.CacheConf{NegativeTTL: 50 * time.Millisecond}}
+		s3Client := newFakeS3Client()
+
+		app, err := New(config, fakeLogger{}, &fakeResizer{}, newFakeCache(), s3Client)
+		require.NoError(t, err)
+
+		_, err = app.ResizeImageByURL(context.Background(), 100, 100, "images", "cat.jpg", nil)
+		require.Truef(t, errors.Is(err, ErrFileNotFound), "actual error %q", err)
+
+		// Uploaded source is rendered once the failure expires.
+		s3Client.put("images", "cat.jpg", []byte("cat"), `"cat"`)
+		_, err = app.ResizeImageByURL(context.Background(), 100, 100, "images", "cat.jpg", nil)
+		require.Truef(t, errors.Is(err, ErrFileNotFound), "actual error %q", err)
+
+		time.Sleep(60 * time.Millisecond)
+
+		resized, err := app.ResizeImageByURL(context.Background(), 100, 100, "images", "cat.jpg", nil)
+		require.NoError(t, err)
+		require.Equal(t, []byte("100x100:cat"), resized.Bytes)
+	})
+
+	t.Run("conditional request of a failed variant", func(t *testing.T) {
+		config := &internalconfig.Config{Cache: internalconfig.CacheConf{NegativeTTL: time.Minute}}
+		s3Client := newFakeS3Client()
+
+		app, err := New(config, fakeLogger{}, &fakeResizer{}, newFakeCache(), s3Client)
+		require.NoError(t, err)
+
+		_, err = app.ResizeImageByURL(context.Background(), 100, 100, "images", "cat.jpg", nil)
+		require.Truef(t, errors.Is(err, ErrFileNotFound), "actual error %q", err)
+
+		_, err = app.GetImageInfo(context.Background(), 100, 100, "images", "cat.jpg")
+		require.Truef(t, errors.Is(err, ErrFileNotFound), "actual error %q", err)
+		require.Equal(t, 0, s3Client.heads)
+	})
+}
+
+func TestImageInfo(t *testing.T) {
+	config := &internalconfig.Config{}
+	s3Client := newFakeS3Client()
+	s3Client.put("images", "cat.jpg", []byte("cat"), `"cat"`)
+	resizer := &fakeResizer{}
+
+	app, err := New(config, fakeLogger{}, resizer, newFakeCache(), s3Client)
+	require.NoError(t, err)
+
+	// Validators of a variant which isn't cached are taken from source metadata.
+	info, err := app.GetImageInfo(context.Background(), 100, 100, "images", "cat.jpg")
+	require.NoError(t, err)
+	require.Equal(t, buildETag(`"cat"`, 100, 100), info.ETag)
+	require.Equal(t, CacheStatusMiss, info.CacheStatus)
+	require.Equal(t, 1, s3Client.heads)
+	require.Equal(t, 0, s3Client.downloadCount())
+	require.Equal(t, 0, resizer.callCount())
+
+	_, err = app.GetImageInfo(context.Background(), 100, 100, "images", "dog.jpg")
+	require.Truef(t, errors.Is(err, ErrFileNotFound), "actual error %q", err)
+
+	// Cached variants are left to ResizeImageByURL.
+	image, err := app.ResizeImageByURL(context.Background(), 100, 100, "images", "cat.jpg", nil)
+	require.NoError(t, err)
+	require.Equal(t, info.ETag, image.ETag)
+
+	_, err = app.GetImageInfo(context.Background(), 100, 100, "images", "cat.jpg")
+	require.Truef(t, errors.Is(err, ErrImageCached), "actual error %q", err)
+	require.Equal(t, 2, s3Client.heads)
+}
+
+func TestTTL(t *testing.T) {
+	config := &internalconfig.Config{
+		Presets: map[string]internalconfig.PresetConf{
+			"thumbnail": {Width: 100, Height: 100, TTL: time.Hour},
+			"icon":      {Width: 100, Height: 100, TTL: time.Minute},
+			"square":    {Width: 100, Height: 100},
+			"banner":    {Width: 800, Height: 200},
+		},
+		Cache: internalconfig.CacheConf{Buckets: map[string]internalconfig.CacheBucketConf{"images": {TTL: 24 * time.Hour}}},
+	}
+
+	app, err := New(config, fakeLogger{}, &fakeResizer{}, newFakeCache(), newFakeS3Client())
+	require.NoError(t, err)
+
+	// Presets with the same sizes are chosen by name, so that the lifetime doesn't change between requests.
+	for i := 0; i < 10; i++ {
+		require.Equal(t, time.Minute, app.ttl(100, 100, "images"))
+	}
+
+	require.Equal(t, 24*time.Hour, app.ttl(800, 200, "images"))
+	require.Equal(t, time.Duration(0), app.ttl(800, 200, "avatars"))
+}
+
+func TestRedirects(t *testing.T) {
+	config := &internalconfig.Config{
+		Redirect: internalconfig.RedirectConf{Bucket: "variants", Prefix: "previews/", MinBytes: 1},
+		Cache:    internalconfig.CacheConf{MaxAge: time.Minute, NegativeTTL: time.Minute},
+	}
+
+	newApp := func(t *testing.T) (*Application, *fakeS3Client) {
+		t.Helper()
+
+		s3Client := newFakeS3Client()
+		for _, key := range []string{"cats/cat.jpg", "cats/cat.jpg/thumbnail.jpg", "dogs/dog.jpg"} {
+			s3Client.put("images", key, []byte(key), `"`+key+`"`)
+		}
+
+		app, err := New(config, fakeLogger{}, &fakeResizer{}, newFakeCache(), s3Client)
+		require.NoError(t, err)
+
+		for _, key := range []string{"cats/cat.jpg", "cats/cat.jpg/thumbnail.jpg", "dogs/dog.jpg"} {
+			image, err := app.ResizeImageByURL(context.Background(), 100, 100, "images", key, nil)
+			require.NoError(t, err)
+			require.Equal(t, "https://s3.example.com/variants/previews/images/"+key+"/100x100", image.RedirectURL)
+		}
+
+		return app, s3Client
+	}
+
+	t.Run("purge object", func(t *testing.T) {
+		app, s3Client := newApp(t)
+
+		require.Equal(t, 1, app.PurgeObject(context.Background(), "images", "cats/cat.jpg"))
+		require.Equal(t, []string{"variants/previews/images/cats/cat.jpg/100x100"}, s3Client.removed)
+	})
+
+	t.Run("purge prefix", func(t *testing.T) {
+		app, s3Client := newApp(t)
+
+		require.Equal(t, 2, app.PurgePrefix(context.Background(), "images", "cats/"))
+		require.ElementsMatch(t, []string{
+			"variants/previews/images/cats/cat.jpg/100x100",
+			"variants/previews/images/cats/cat.jpg/thumbnail.jpg/100x100",
+		}, s3Client.removed)
+	})
+
+	t.Run("removed source", func(t *testing.T) {
+		app, s3Client := newApp(t)
+
+		// Negative item replaces the variant once its source is deleted.
+		require.NoError(t, s3Client.Remove(context.Background(), "images", "dogs/dog.jpg"))
+		s3Client.removed = nil
+		item, err := app.Cache.Get(buildCacheKey(100, 100, "images", "dogs/dog.jpg"))
+		require.NoError(t, err)
+		item.ValidatedAt = time.Now().Add(-time.Hour)
+		require.NoError(t, app.Cache.Set(buildCacheKey(100, 100, "images", "dogs/dog.jpg"), item))
+
+		_, err = app.ResizeImageByURL(context.Background(), 100, 100, "images", "dogs/dog.jpg", nil)
 		require.Truef(t, errors.Is(err, ErrFileNotFound), "actual error %q", err)
+		require.Equal(t, []string{"variants/previews/images/dogs/dog.jpg/100x100"}, s3Client.removed)
+	})
+
+	t.Run("expired variant of removed source", func(t *testing.T) {
+		app, s3Client := newApp(t)
+
+		require.NoError(t, s3Client.Remove(context.Background(), "images", "dogs/dog.jpg"))
+		s3Client.removed = nil
+		item, err := app.Cache.Get(buildCacheKey(100, 100, "images", "dogs/dog.jpg"))
+		require.NoError(t, err)
+		item.ExpiresAt = time.Now().Add(-time.Second)
+		require.NoError(t, app.Cache.Set(buildCacheKey(100, 100, "images", "dogs/dog.jpg"), item))
+
+		_, err = app.ResizeImageByURL(context.Background(), 100, 100, "images", "dogs/dog.jpg", nil)
+		require.Truef(t, errors.Is(err, ErrFileNotFound), "actual error %q", err)
+		require.Equal(t, []string{"variants/previews/images/dogs/dog.jpg/100x100"}, s3Client.removed)
+	})
+
+	t.Run("shrunk variant", func(t *testing.T) {
+		config := *config
+		config.Redirect.MinBytes = int64(len("100x100:cats/cat.jpg"))
+
+		s3Client := newFakeS3Client()
+		s3Client.put("images", "cats/cat.jpg", []byte("cats/cat.jpg"), `"v1"`)
+
+		app, err := New(&config, fakeLogger{}, &fakeResizer{}, newFakeCache(), s3Client)
+		require.NoError(t, err)
+
+		image, err := app.ResizeImageByURL(context.Background(), 100, 100, "images", "cats/cat.jpg", nil)
+		require.NoError(t, err)
+		require.NotEmpty(t, image.RedirectURL)
+
+		// Variant rendered again is small enough to be cached itself, so its stored image is removed.
+		s3Client.put("images", "cats/cat.jpg", []byte("cat"), `"v2"`)
+		item, err := app.Cache.Get(buildCacheKey(100, 100, "images", "cats/cat.jpg"))
+		require.NoError(t, err)
+		item.ValidatedAt = time.Now().Add(-time.Hour)
+		require.NoError(t, app.Cache.Set(buildCacheKey(100, 100, "images", "cats/cat.jpg"), item))
+
+		image, err = app.ResizeImageByURL(context.Background(), 100, 100, "images", "cats/cat.jpg", nil)
+		require.NoError(t, err)
+		require.Empty(t, image.RedirectURL)
+		require.Equal(t, []byte("100x100:cat"), image.Bytes)
+		require.Equal(t, []string{"variants/previews/images/cats/cat.jpg/100x100"}, s3Client.removed)
+	})
+}
+
+func TestStaleVariants(t *testing.T) {
+	// Variants are revalidated on every request, and kept for an hour after their lifetime.
+	config := &internalconfig.Config{Cache: internalconfig.CacheConf{TTL: time.Hour, StaleIfError: time.Hour}}
+
+	t.Run("lifetime", func(t *testing.T) {
+		app, err := New(config, fakeLogger{}, &fakeResizer{}, newFakeCache(), newFakeS3Client())
+		require.NoError(t, err)
+
+		item := &internalcache.Item{}
+		app.setLifetime(item, 100, 100, "images")
+		require.WithinDuration(t, time.Now().Add(time.Hour), item.StaleAt, time.Second)
+		require.Equal(t, item.StaleAt.Add(time.Hour), item.ExpiresAt)
+	})
+
+	for _, s3Err := range []error{errors.New("503 service unavailable"), context.DeadlineExceeded} {
+		s3Err := s3Err
+		t.Run("stale if error: "+s3Err.Error(), func(t *testing.T) {
+			s3Client := newFakeS3Client()
+			s3Client.put("images", "cat.jpg", []byte("cat"), `"cat"`)
+
+			app, err := New(config, fakeLogger{}, &fakeResizer{}, newFakeCache(), s3Client)
+			require.NoError(t, err)
+
+			_, err = app.ResizeImageByURL(context.Background(), 100, 100, "images", "cat.jpg", nil)
+			require.NoError(t, err)
+
+			s3Client.setErr(s3Err)
+			stale, err := app.ResizeImageByURL(context.Background(), 100, 100, "images", "cat.jpg", nil)
+			require.NoError(t, err)
+			require.Equal(t, []byte("100x100:cat"), stale.Bytes)
+			require.Equal(t, WarningRevalidationFailed, stale.Warning)
+			require.Equal(t, CacheStatusStale, stale.CacheStatus)
+
+			// Following requests don't wait for s3 until revalidation is retried.
+			stale, err = app.ResizeImageByURL(context.Background(), 100, 100, "images", "cat.jpg", nil)
+			require.NoError(t, err)
+			require.Equal(t, WarningRevalidationFailed, stale.Warning)
+			require.Equal(t, 2, s3Client.downloadCount())
+
+			// Once the source is reachable, retried revalidation refreshes the variant in background.
+			s3Client.setErr(nil)
+			app.unreachable.Store(buildCacheKey(100, 100, "images", "cat.jpg"), time.Now())
+			_, err = app.ResizeImageByURL(context.Background(), 100, 100, "images", "cat.jpg", nil)
+			require.NoError(t, err)
+			require.Eventually(t, func() bool {
+				return !app.isUnreachable(buildCacheKey(100, 100, "images", "cat.jpg"))
+			}, time.Second, 10*time.Millisecond)
+
+			fresh, err := app.ResizeImageByURL(context.Background(), 100, 100, "images", "cat.jpg", nil)
+			require.NoError(t, err)
+			require.Empty(t, fresh.Warning)
+			require.Equal(t, CacheStatusRevalidated, fresh.CacheStatus)
+		})
+	}
+
+	t.Run("nothing stale", func(t *testing.T) {
+		s3Client := newFakeS3Client()
+		s3Client.setErr(errors.New("503 service unavailable"))
+
+		app, err := New(config, fakeLogger{}, &fakeResizer{}, newFakeCache(), s3Client)
+		require.NoError(t, err)
+
+		_, err = app.ResizeImageByURL(context.Background(), 100, 100, "images", "cat.jpg", nil)
+		require.Truef(t, errors.Is(err, ErrDownload), "actual error %q", err)
+	})
+
+	t.Run("stale while revalidate", func(t *testing.T) {
+		config := &internalconfig.Config{Cache: internalconfig.CacheConf{StaleWhileRevalidate: time.Minute}}
+		s3Client := newFakeS3Client()
+		s3Client.put("images", "cat.jpg", []byte("cat"), `"cat"`)
+		cache := newFakeCache()
+
+		app, err := New(config, fakeLogger{}, &fakeResizer{}, cache, s3Client)
+		require.NoError(t, err)
+
+		_, err = app.ResizeImageByURL(context.Background(), 100, 100, "images", "cat.jpg", nil)
+		require.NoError(t, err)
+
+		// Changed source is rendered in background, while the stale variant is served.
+		s3Client.put("images", "cat.jpg", []byte("new cat"), `"new"`)
+		stale, err := app.ResizeImageByURL(context.Background(), 100, 100, "images", "cat.jpg", nil)
+		require.NoError(t, err)
+		require.Equal(t, []byte("100x100:cat"), stale.Bytes)
+		require.Equal(t, WarningStale, stale.Warning)
+
+		require.Eventually(t, func() bool {
+			item, exists := cache.get(buildCacheKey(100, 100, "images", "cat.jpg"))
+			return exists && bytes.Equal(item.Value, []byte("100x100:new cat"))
+		}, time.Second, 10*time.Millisecond)
+		require.Equal(t, 2, s3Client.downloadCount())
+	})
+
+	t.Run("revalidation in jobs context", func(t *testing.T) {
+		config := &internalconfig.Config{Cache: internalconfig.CacheConf{StaleWhileRevalidate: time.Minute}}
+		s3Client := newFakeS3Client()
+		s3Client.put("images", "cat.jpg", []byte("cat"), `"cat"`)
+		cache := newFakeCache()
+
+		app, err := New(config, fakeLogger{}, &fakeResizer{}, cache, s3Client)
+		require.NoError(t, err)
+
+		_, err = app.ResizeImageByURL(context.Background(), 100, 100, "images", "cat.jpg", nil)
+		require.NoError(t, err)
+
+		// Background revalidation is cancelled together with the jobs, and it's waited for.
+		jobs, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		s3Client.put("images", "cat.jpg", []byte("new cat"), `"new"`)
+		stale, err := app.ResizeImageByURL(ContextWithJobs(context.Background(), jobs), 100, 100, "images", "cat.jpg", nil)
+		require.NoError(t, err)
+		require.Equal(t, WarningStale, stale.Warning)
+
+		app.Wait()
+		require.Equal(t, 2, s3Client.downloadCount())
+
+		item, exists := cache.get(buildCacheKey(100, 100, "images", "cat.jpg"))
+		require.True(t, exists)
+		require.Equal(t, []byte("100x100:cat"), item.Value)
+	})
+
+	t.Run("stale if error window", func(t *testing.T) {
+		config := &internalconfig.Config{Cache: internalconfig.CacheConf{TTL: time.Hour, StaleIfError: time.Minute}}
+		s3Client := newFakeS3Client()
+		s3Client.put("images", "cat.jpg", []byte("cat"), `"cat"`)
+		cache := newFakeCache()
+
+		app, err := New(config, fakeLogger{}, &fakeResizer{}, cache, s3Client)
+		require.NoError(t, err)
+
+		_, err = app.ResizeImageByURL(context.Background(), 100, 100, "images", "cat.jpg", nil)
+		require.NoError(t, err)
+
+		// Variants stale for longer than the window aren't served when the source is unreachable,
+		// even if it was found unreachable before.
+		cacheKey := buildCacheKey(100, 100, "images", "cat.jpg")
+		item, exists := cache.get(cacheKey)
+		require.True(t, exists)
+		item.ValidatedAt = time.Now().Add(-2 * time.Minute)
+		require.NoError(t, cache.Set(cacheKey, &item))
+		app.unreachable.Store(cacheKey, time.Now().Add(time.Minute))
+
+		s3Client.setErr(errors.New("503 service unavailable"))
+		_, err = app.ResizeImageByURL(context.Background(), 100, 100, "images", "cat.jpg", nil)
+		require.Truef(t, errors.Is(err, ErrDownload), "actual error %q", err)
+		require.Equal(t, 2, s3Client.downloadCount())
+	})
+
+	t.Run("pruning unreachable marks", func(t *testing.T) {
+		app, err := New(config, fakeLogger{}, &fakeResizer{}, newFakeCache(), newFakeS3Client())
+		require.NoError(t, err)
+
+		// Marks of variants which weren't requested during the stale-if-error window are dropped.
+		app.unreachable.Store("forgotten", time.Now().Add(-2*time.Hour))
+		app.unreachable.Store("requested", time.Now().Add(-time.Minute))
+		app.markUnreachable("failed")
+
+		require.False(t, app.isUnreachable("forgotten"))
+		require.True(t, app.isUnreachable("requested"))
+		require.True(t, app.isUnreachable("failed"))
 	})
 }
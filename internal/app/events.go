@@ -0,0 +1,72 @@
+package app
+
+import (
+	"context"
+)
+
+const (
+	ObjectEventCreated = "created"
+	ObjectEventRemoved = "removed"
+)
+
+// ObjectEvent is a change of a source object.
+type ObjectEvent struct {
+	Type   string
+	Bucket string
+	Key    string
+}
+
+// ObjectEventsResult describes handling of object events. Job is set when variants of created objects are rendered.
+type ObjectEventsResult struct {
+	Purged int        `json:"purged"`
+	Job    *WarmupJob `json:"job,omitempty"`
+}
+
+// HandleObjectEvents purges cached variants of the changed objects, and renders configured presets of created ones.
+func (app *Application) HandleObjectEvents(ctx context.Context, events []ObjectEvent) ObjectEventsResult {
+	result := ObjectEventsResult{}
+
+	// The same object could be created and removed within the events, so only the last event of an object counts.
+	created := make(map[[2]string]bool)
+	var order [][2]string
+
+	for _, event := range events {
+		// Created object could overwrite the previous one, so its variants are purged as well.
+		result.Purged += app.PurgeObject(ctx, event.Bucket, event.Key)
+
+		object := [2]string{event.Bucket, event.Key}
+		if _, seen := created[object]; !seen {
+			order = append(order, object)
+		}
+		created[object] = event.Type == ObjectEventCreated
+	}
+
+	presets := app.Config.GetEventsPresets()
+	if len(presets) == 0 {
+		return result
+	}
+
+	request := WarmupRequest{}
+	for _, object := range order {
+		if !created[object] {
+			continue
+		}
+
+		for _, preset := range presets {
+			request.Targets = append(request.Targets, WarmupTarget{Bucket: object[0], Key: object[1], Preset: preset})
+		}
+	}
+
+	if len(request.Targets) == 0 {
+		return result
+	}
+
+	job, err := app.StartWarmup(ctx, request)
+	if err != nil {
+		app.Logger.Error(err)
+		return result
+	}
+	result.Job = &job
+
+	return result
+}
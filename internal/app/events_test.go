@@ -0,0 +1,49 @@
+package app
+
+import (
+	"context"
+	"testing"
+
+	internalcache "github.com/spendmail/s3_previewer/internal/cache"
+	internalconfig "github.com/spendmail/s3_previewer/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestObjectEvents(t *testing.T) {
+	config := &internalconfig.Config{Presets: testPresets, Events: internalconfig.EventsConf{Presets: []string{"small"}}}
+
+	s3Client := newFakeS3Client()
+	for _, key := range []string{"1.jpg", "2.jpg", "3.jpg"} {
+		s3Client.put("images", key, []byte(key), `"`+key+`"`)
+	}
+	cache := newFakeCache()
+	require.NoError(t, cache.Set(buildCacheKey(100, 100, "images", "2.jpg"), &internalcache.Item{Value: []byte("2"), Bucket: "images", Key: "2.jpg"}))
+	require.NoError(t, cache.Set(buildCacheKey(100, 100, "images", "3.jpg"), &internalcache.Item{Value: []byte("3"), Bucket: "images", Key: "3.jpg"}))
+
+	app, err := New(config, fakeLogger{}, &fakeResizer{}, cache, s3Client)
+	require.NoError(t, err)
+
+	// Only the last event of an object counts: the object deleted after its creation isn't rendered.
+	result := app.HandleObjectEvents(context.Background(), []ObjectEvent{
+		{Type: ObjectEventCreated, Bucket: "images", Key: "1.jpg"},
+		{Type: ObjectEventCreated, Bucket: "images", Key: "2.jpg"},
+		{Type: ObjectEventRemoved, Bucket: "images", Key: "2.jpg"},
+		{Type: ObjectEventRemoved, Bucket: "images", Key: "3.jpg"},
+		{Type: ObjectEventCreated, Bucket: "images", Key: "3.jpg"},
+	})
+	require.Equal(t, 2, result.Purged)
+	require.NotNil(t, result.Job)
+
+	job := waitWarmup(t, app, result.Job.ID)
+	require.Equal(t, WarmupStatusDone, job.Status)
+	require.Equal(t, 2, job.Total)
+	require.Equal(t, 0, job.Failed)
+
+	for key, rendered := range map[string]bool{"1.jpg": true, "2.jpg": false, "3.jpg": true} {
+		_, exists := cache.get(buildCacheKey(10, 10, "images", key))
+		require.Equal(t, rendered, exists, key)
+	}
+
+	_, exists := cache.get(buildCacheKey(100, 100, "images", "3.jpg"))
+	require.False(t, exists)
+}
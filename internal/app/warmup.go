@@ -0,0 +1,326 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	WarmupStatusRunning  = "running"
+	WarmupStatusDone     = "done"
+	WarmupStatusFailed   = "failed"
+	defaultWarmupWorkers = 4
+	// Finished jobs are forgotten once there are more jobs than this.
+	maxWarmupJobs = 100
+)
+
+var (
+	ErrWarmupRequest = errors.New("invalid warm-up request")
+	ErrWarmupFile    = errors.New("unable to read warm-up file")
+)
+
+// WarmupTarget is a variant to be rendered: either sizes or a preset name are given.
+type WarmupTarget struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+	Preset string `json:"preset,omitempty"`
+}
+
+// WarmupRequest lists variants to be rendered: the targets, and objects under the prefix in the given presets.
+// Empty presets list means all presets.
+type WarmupRequest struct {
+	Targets []WarmupTarget `json:"targets"`
+	Bucket  string         `json:"bucket,omitempty"`
+	Prefix  string         `json:"prefix,omitempty"`
+	Presets []string       `json:"presets,omitempty"`
+}
+
+// WarmupJob describes progress of rendering variants in background.
+type WarmupJob struct {
+	ID         string    `json:"id"`
+	Status     string    `json:"status"`
+	Total      int       `json:"total"`
+	Processed  int       `json:"processed"`
+	Failed     int       `json:"failed"`
+	Error      string    `json:"error,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// warmupTask is a single variant with resolved sizes.
+type warmupTask struct {
+	bucket, key   string
+	width, height int
+}
+
+// warmup keeps jobs of the application.
+type warmup struct {
+	mutex sync.Mutex
+	jobs  map[string]*WarmupJob
+	order []string
+}
+
+// StartWarmup validates the request and renders its variants in background. Returned job is a snapshot.
+func (app *Application) StartWarmup(ctx context.Context, request WarmupRequest) (WarmupJob, error) {
+	tasks, err := app.resolveTargets(request.Targets)
+	if err != nil {
+		return WarmupJob{}, err
+	}
+
+	if request.Prefix != "" && request.Bucket == "" {
+		return WarmupJob{}, fmt.Errorf("%w: bucket of the prefix is missing", ErrWarmupRequest)
+	}
+
+	if len(tasks) == 0 && request.Bucket == "" {
+		return WarmupJob{}, fmt.Errorf("%w: nothing to render", ErrWarmupRequest)
+	}
+
+	presets, err := app.resolvePresets(request.Presets)
+	if err != nil {
+		return WarmupJob{}, err
+	}
+
+	if request.Bucket != "" && len(presets) == 0 {
+		return WarmupJob{}, fmt.Errorf("%w: there are no presets for the prefix", ErrWarmupRequest)
+	}
+
+	job := app.warmup.add(len(tasks))
+
+	go func() {
+		if request.Bucket != "" {
+			prefixTasks, err := app.listPrefix(ctx, request.Bucket, request.Prefix, presets)
+			if err != nil {
+				app.Logger.Error(err)
+				app.warmup.finish(job.ID, err)
+				return
+			}
+
+			tasks = append(tasks, prefixTasks...)
+			app.warmup.update(job.ID, func(job *WarmupJob) {
+				job.Total = len(tasks)
+			})
+		}
+
+		app.runWarmup(ctx, job.ID, tasks)
+		app.warmup.finish(job.ID, ctx.Err())
+
+		finished, _ := app.GetWarmupJob(job.ID)
+		app.Logger.Info(fmt.Sprintf(
+			"warm-up job %s is %s: %d of %d variants rendered, %d failed",
+			finished.ID, finished.Status, finished.Processed-finished.Failed, finished.Total, finished.Failed,
+		))
+	}()
+
+	return job, nil
+}
+
+// StartWarmupFromFile starts warm-up of the request stored in JSON file.
+func (app *Application) StartWarmupFromFile(ctx context.Context, path string) (WarmupJob, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return WarmupJob{}, fmt.Errorf("%w: %s", ErrWarmupFile, err)
+	}
+
+	var request WarmupRequest
+	if err := json.Unmarshal(content, &request); err != nil {
+		return WarmupJob{}, fmt.Errorf("%w: %s", ErrWarmupFile, err)
+	}
+
+	return app.StartWarmup(ctx, request)
+}
+
+// GetWarmupJob returns a snapshot of the job.
+func (app *Application) GetWarmupJob(id string) (WarmupJob, bool) {
+	app.warmup.mutex.Lock()
+	defer app.warmup.mutex.Unlock()
+
+	job, exists := app.warmup.jobs[id]
+	if !exists {
+		return WarmupJob{}, false
+	}
+
+	return *job, true
+}
+
+// runWarmup renders tasks with bounded concurrency, until context is done.
+func (app *Application) runWarmup(ctx context.Context, id string, tasks []warmupTask) {
+	workers := app.Config.GetWarmupConcurrency()
+	if workers <= 0 {
+		workers = defaultWarmupWorkers
+	}
+
+	queue := make(chan warmupTask)
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for task := range queue {
+				_, err := app.ResizeImageByURL(ctx, task.width, task.height, task.bucket, task.key, nil)
+				if err != nil {
+					app.Logger.Warn(fmt.Sprintf("unable to warm up %s/%s: %s", task.bucket, task.key, err))
+				}
+
+				app.warmup.update(id, func(job *WarmupJob) {
+					job.Processed++
+					if err != nil {
+						job.Failed++
+					}
+				})
+			}
+		}()
+	}
+
+queueing:
+	for _, task := range tasks {
+		select {
+		case queue <- task:
+		case <-ctx.Done():
+			break queueing
+		}
+	}
+
+	close(queue)
+	wg.Wait()
+}
+
+// resolveTargets converts targets into tasks, looking up preset sizes.
+func (app *Application) resolveTargets(targets []WarmupTarget) ([]warmupTask, error) {
+	presets := app.Config.GetPresets()
+	tasks := make([]warmupTask, 0, len(targets))
+
+	for _, target := range targets {
+		task := warmupTask{bucket: target.Bucket, key: target.Key, width: target.Width, height: target.Height}
+
+		if target.Preset != "" {
+			preset, exists := presets[target.Preset]
+			if !exists {
+				return nil, fmt.Errorf("%w: unknown preset %s", ErrWarmupRequest, target.Preset)
+			}
+			task.width, task.height = preset.Width, preset.Height
+		}
+
+		if task.bucket == "" || task.key == "" || task.width <= 0 || task.height <= 0 {
+			return nil, fmt.Errorf("%w: incomplete target %s/%s", ErrWarmupRequest, target.Bucket, target.Key)
+		}
+
+		tasks = append(tasks, task)
+	}
+
+	return tasks, nil
+}
+
+// resolvePresets returns sizes of the named presets, or of all presets if there are no names.
+func (app *Application) resolvePresets(names []string) ([][2]int, error) {
+	presets := app.Config.GetPresets()
+
+	if len(names) == 0 {
+		for name := range presets {
+			names = append(names, name)
+		}
+		// Presets are rendered in the same order every time.
+		sort.Strings(names)
+	}
+
+	sizes := make([][2]int, 0, len(names))
+	for _, name := range names {
+		preset, exists := presets[name]
+		if !exists {
+			return nil, fmt.Errorf("%w: unknown preset %s", ErrWarmupRequest, name)
+		}
+		sizes = append(sizes, [2]int{preset.Width, preset.Height})
+	}
+
+	return sizes, nil
+}
+
+// listPrefix builds tasks for every object under the prefix in every preset.
+func (app *Application) listPrefix(ctx context.Context, bucket, prefix string, presets [][2]int) ([]warmupTask, error) {
+	keys, err := app.S3Client.List(ctx, bucket, prefix)
+	if err != nil {
+		return nil, wrapS3Error(err)
+	}
+
+	tasks := make([]warmupTask, 0, len(keys)*len(presets))
+	for _, key := range keys {
+		// Keys ending with a slash are folder placeholders rather than images.
+		if strings.HasSuffix(key, "/") {
+			continue
+		}
+
+		for _, sizes := range presets {
+			tasks = append(tasks, warmupTask{bucket: bucket, key: key, width: sizes[0], height: sizes[1]})
+		}
+	}
+
+	return tasks, nil
+}
+
+// add registers a new running job, forgetting the oldest finished ones.
+func (w *warmup) add(total int) WarmupJob {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.jobs == nil {
+		w.jobs = make(map[string]*WarmupJob)
+	}
+
+	job := &WarmupJob{ID: newJobID(), Status: WarmupStatusRunning, Total: total, StartedAt: time.Now()}
+	w.jobs[job.ID] = job
+	w.order = append(w.order, job.ID)
+
+	for i := 0; len(w.order) > maxWarmupJobs && i < len(w.order); {
+		if w.jobs[w.order[i]].Status == WarmupStatusRunning {
+			i++
+			continue
+		}
+
+		delete(w.jobs, w.order[i])
+		w.order = append(w.order[:i], w.order[i+1:]...)
+	}
+
+	return *job
+}
+
+// update changes the job under the lock.
+func (w *warmup) update(id string, change func(job *WarmupJob)) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if job, exists := w.jobs[id]; exists {
+		change(job)
+	}
+}
+
+// finish marks the job as done, or as failed if there is an error.
+func (w *warmup) finish(id string, err error) {
+	w.update(id, func(job *WarmupJob) {
+		job.Status = WarmupStatusDone
+		if err != nil {
+			job.Status = WarmupStatusFailed
+			job.Error = err.Error()
+		}
+		job.FinishedAt = time.Now()
+	})
+}
+
+// newJobID generates a random job identifier.
+func newJobID() string {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,124 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	internalconfig "github.com/spendmail/s3_previewer/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+var testPresets = map[string]internalconfig.PresetConf{
+	"small": {Width: 10, Height: 10},
+	"large": {Width: 100, Height: 100},
+}
+
+// waitWarmup waits until the job is finished and returns it.
+func waitWarmup(t *testing.T, app *Application, id string) WarmupJob {
+	t.Helper()
+
+	var job WarmupJob
+	require.Eventually(t, func() bool {
+		job, _ = app.GetWarmupJob(id)
+		return job.Status != WarmupStatusRunning
+	}, time.Second, 10*time.Millisecond)
+
+	return job
+}
+
+func TestWarmup(t *testing.T) {
+	config := &internalconfig.Config{Presets: testPresets, Warmup: internalconfig.WarmupConf{Concurrency: 2}}
+
+	t.Run("targets and prefix", func(t *testing.T) {
+		s3Client := newFakeS3Client()
+		for _, key := range []string{"a/", "a/1.jpg", "a/2.jpg", "b/3.jpg"} {
+			s3Client.put("images", key, []byte(key), `"`+key+`"`)
+		}
+		cache := newFakeCache()
+
+		app, err := New(config, fakeLogger{}, &fakeResizer{}, cache, s3Client)
+		require.NoError(t, err)
+
+		job, err := app.StartWarmup(context.Background(), WarmupRequest{
+			Targets: []WarmupTarget{
+				{Bucket: "images", Key: "b/3.jpg", Width: 20, Height: 30},
+				{Bucket: "images", Key: "a/missing.jpg", Preset: "small"},
+			},
+			Bucket: "images",
+			Prefix: "a/",
+		})
+		require.NoError(t, err)
+		require.Equal(t, WarmupStatusRunning, job.Status)
+
+		// Folder placeholder is skipped, objects under the prefix are rendered in all presets.
+		job = waitWarmup(t, app, job.ID)
+		require.Equal(t, WarmupStatusDone, job.Status)
+		require.Equal(t, 6, job.Total)
+		require.Equal(t, 6, job.Processed)
+		require.Equal(t, 1, job.Failed)
+
+		for _, variant := range []struct {
+			width, height int
+			key           string
+		}{{20, 30, "b/3.jpg"}, {10, 10, "a/1.jpg"}, {100, 100, "a/1.jpg"}, {10, 10, "a/2.jpg"}, {100, 100, "a/2.jpg"}} {
+			_, exists := cache.get(buildCacheKey(variant.width, variant.height, "images", variant.key))
+			require.True(t, exists, variant)
+		}
+
+		_, exists := cache.get(buildCacheKey(10, 10, "images", "b/3.jpg"))
+		require.False(t, exists)
+	})
+
+	t.Run("named presets", func(t *testing.T) {
+		s3Client := newFakeS3Client()
+		s3Client.put("images", "a/1.jpg", []byte("a/1.jpg"), `"1"`)
+		cache := newFakeCache()
+
+		app, err := New(config, fakeLogger{}, &fakeResizer{}, cache, s3Client)
+		require.NoError(t, err)
+
+		job, err := app.StartWarmup(context.Background(), WarmupRequest{Bucket: "images", Prefix: "a/", Presets: []string{"large"}})
+		require.NoError(t, err)
+
+		job = waitWarmup(t, app, job.ID)
+		require.Equal(t, 1, job.Total)
+
+		_, exists := cache.get(buildCacheKey(100, 100, "images", "a/1.jpg"))
+		require.True(t, exists)
+		_, exists = cache.get(buildCacheKey(10, 10, "images", "a/1.jpg"))
+		require.False(t, exists)
+	})
+
+	t.Run("failed listing", func(t *testing.T) {
+		s3Client := newFakeS3Client()
+		s3Client.setErr(errors.New("503 service unavailable"))
+
+		app, err := New(config, fakeLogger{}, &fakeResizer{}, newFakeCache(), s3Client)
+		require.NoError(t, err)
+
+		job, err := app.StartWarmup(context.Background(), WarmupRequest{Bucket: "images"})
+		require.NoError(t, err)
+
+		job = waitWarmup(t, app, job.ID)
+		require.Equal(t, WarmupStatusFailed, job.Status)
+		require.NotEmpty(t, job.Error)
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		app, err := New(config, fakeLogger{}, &fakeResizer{}, newFakeCache(), newFakeS3Client())
+		require.NoError(t, err)
+
+		for name, request := range map[string]WarmupRequest{
+			"nothing to render":          {},
+			"unknown preset":             {Targets: []WarmupTarget{{Bucket: "images", Key: "1.jpg", Preset: "huge"}}},
+			"incomplete target":          {Targets: []WarmupTarget{{Bucket: "images", Key: "1.jpg"}}},
+			"prefix of nothing":          {Prefix: "a/"},
+			"unknown preset of a prefix": {Bucket: "images", Presets: []string{"huge"}},
+		} {
+			_, err := app.StartWarmup(context.Background(), request)
+			require.Truef(t, errors.Is(err, ErrWarmupRequest), "%s: actual error %q", name, err)
+		}
+	})
+}
@@ -1,18 +1,32 @@
 package cache
 
 import (
-	"encoding/base64"
+	"context"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
+
+	internalTracing "github.com/spendmail/s3_previewer/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	tmpDirName     = ".tmp"
+	tmpFilePattern = "*"
 )
 
 type Config interface {
 	GetCacheCapacity() int64
+	GetCacheMaxBytes() int64
+	GetCacheShards() int
 	GetCachePath() string
+	GetCacheTTL() time.Duration
+	GetCacheJanitorInterval() time.Duration
 }
 
 type Logger interface {
@@ -22,18 +36,62 @@ type Logger interface {
 	Error(args ...interface{})
 }
 
+// LruCache is a file-backed cache. Its index is split into shards with their own locks and LRU queues,
+// and values are read and written outside of the locks.
 type LruCache struct {
-	capacity int64
-	queue    List
-	items    map[string]*ListItem
-	path     string
-	logger   Logger
-	mutex    sync.Mutex
+	counters     counters
+	shards       []*shard
+	ttl          time.Duration
+	path         string
+	journal      *journal
+	journalMutex sync.Mutex
+	// syncMutex serializes syncs and compactions of the journal, synced is the latest synced record.
+	syncMutex   sync.Mutex
+	synced      uint64
+	touches     chan string
+	compaction  chan struct{}
+	logger      Logger
+	done        chan struct{}
+	closeOnce   sync.Once
+	wg          sync.WaitGroup
+	restored    chan struct{}
+	statusMutex sync.Mutex
+	status      RestoreStatus
+	tombstones  *tombstones
+}
+
+// Item is a cached value together with the metadata of the source object it was rendered from.
+type Item struct {
+	Value []byte `json:"-"`
+	// Bucket and Key identify the source object, used for purging all its variants.
+	Bucket       string    `json:"bucket,omitempty"`
+	Key          string    `json:"key,omitempty"`
+	ContentType  string    `json:"content_type,omitempty"`
+	SourceETag   string    `json:"source_etag,omitempty"`
+	LastModified time.Time `json:"last_modified"`
+	ValidatedAt  time.Time `json:"validated_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	// StaleAt is the end of the variant lifetime, when it's kept until ExpiresAt to be served if refreshing fails.
+	StaleAt time.Time `json:"stale_at"`
+	// Location is a key of the variant stored in the redirect bucket, when it isn't kept in cache itself.
+	Location string `json:"location,omitempty"`
+	// Failure is set for negative items, which keep the reason of a failed rendering instead of the value.
+	Failure string `json:"failure,omitempty"`
+	// CreatedAt, Size and Checksum are filled by cache when the value is stored.
+	CreatedAt time.Time `json:"created_at"`
+	Size      int64     `json:"size"`
+	Checksum  string    `json:"checksum,omitempty"`
+}
+
+// IsExpired checks whether item lifetime is over. Zero ExpiresAt means item never expires.
+func (i Item) IsExpired(now time.Time) bool {
+	return !i.ExpiresAt.IsZero() && !now.Before(i.ExpiresAt)
 }
 
 type cacheItem struct {
 	key   string
 	value string
+	meta  Item
 }
 
 var (
@@ -41,19 +99,66 @@ var (
 	ErrFileRemove    = errors.New("unable to remove file from filesystem")
 	ErrFileRead      = errors.New("unable to read file from filesystem")
 	ErrItemNotExists = errors.New("cache item does not exist")
+	ErrItemExpired   = errors.New("cache item is expired")
+	ErrItemTooLarge  = errors.New("cache item exceeds cache size")
+	ErrItemCorrupted = errors.New("cache item is corrupted")
 )
 
+var checksumTable = crc32.MakeTable(crc32.Castagnoli)
+
 // New is a cache constructor: returns lruCache instance pointer.
 func New(config Config, logger Logger) (*LruCache, error) {
+	cache, err := open(config, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	// Cache is restored in background, so items appear in it gradually, and misses are served meanwhile.
+	cache.wg.Add(1)
+	go func() {
+		defer cache.wg.Done()
+		cache.restoreFromFilesystem()
+	}()
+
+	cache.startJournalWriter()
+	cache.startJanitor(config.GetCacheJanitorInterval())
+
+	return cache, nil
+}
+
+// open prepares cache directory and journal, leaving restoration and background jobs to the caller.
+func open(config Config, logger Logger) (*LruCache, error) {
 	cache := LruCache{
-		capacity: config.GetCacheCapacity(),
-		path:     config.GetCachePath(),
-		queue:    NewList(),
-		items:    make(map[string]*ListItem, config.GetCacheCapacity()),
-		logger:   logger,
+		shards:     newShards(config.GetCacheShards(), config.GetCacheCapacity(), config.GetCacheMaxBytes()),
+		ttl:        config.GetCacheTTL(),
+		path:       config.GetCachePath(),
+		compaction: make(chan struct{}, 1),
+		touches:    make(chan string, journalTouchQueue),
+		logger:     logger,
+		done:       make(chan struct{}),
+		restored:   make(chan struct{}),
+		tombstones: newTombstones(),
+	}
+
+	err := os.MkdirAll(cache.path, os.ModePerm)
+	if err != nil {
+		return nil, err
 	}
 
-	err := cache.restoreFromFilesystem()
+	// Temporary files left by interrupted writes are useless.
+	err = os.RemoveAll(cache.tmpPath())
+	if err == nil {
+		err = os.MkdirAll(cache.tmpPath(), os.ModePerm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cache.journal, err = prepareJournal(
+		filepath.Join(cache.path, journalFileName),
+		filepath.Join(cache.path, restoreJournalFileName),
+		logger,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -61,108 +166,321 @@ func New(config Config, logger Logger) (*LruCache, error) {
 	return &cache, nil
 }
 
-// Get is a LruCache getter: returns value if exists, or error, if doesnt.
-func (l *LruCache) Get(key string) ([]byte, error) {
-	l.mutex.Lock()
-	defer l.mutex.Unlock()
+// Get is a LruCache getter: returns item if exists, or error, if doesnt.
+func (l *LruCache) Get(key string) (*Item, error) {
+	return l.GetContext(context.Background(), key)
+}
+
+// GetContext is Get traced within the trace of ctx.
+func (l *LruCache) GetContext(ctx context.Context, key string) (item *Item, err error) {
+	ctx, span := startSpan(ctx, "cache.lru.Get", attribute.String("cache.key", key))
+	defer func() {
+		endGetSpan(span, err)
+	}()
+
+	return l.get(ctx, key)
+}
+
+// get reads item, reading it again if it has been replaced while its file was read.
+func (l *LruCache) get(ctx context.Context, key string) (*Item, error) {
+	s := l.shard(key)
+	s.mutex.Lock()
 
-	item, exists := l.items[key]
+	item, exists := s.items[key]
 
-	// If cache element doesn't exist, return empty slice
+	// If cache element doesn't exist, return nil
 	if !exists {
-		return []byte{}, ErrItemNotExists
+		s.mutex.Unlock()
+		increment(&l.counters.misses)
+
+		return nil, ErrItemNotExists
 	}
 
 	// To get actual value, interface{} needs to be casted to cacheItem
 	cacheItemElement := item.Value.(cacheItem)
-	filename := cacheItemElement.value
+
+	// Expired elements are removed right away
+	if cacheItemElement.meta.IsExpired(time.Now()) {
+		l.removeElement(s, item)
+		s.mutex.Unlock()
+		increment(&l.counters.misses)
+		increment(&l.counters.evictions)
+
+		return nil, ErrItemExpired
+	}
+
+	// Element is moved to front before reading, so that it isn't evicted meanwhile
+	s.queue.MoveToFront(item)
+	s.mutex.Unlock()
+	l.touch(key)
 
 	// Reading from filesystem
-	value, err := l.readFromFileSystem(filename)
+	_, readSpan := startSpan(ctx, "cache.lru.ReadFile")
+	value, err := l.readFromFileSystem(cacheItemElement.value)
+	internalTracing.End(readSpan, err)
 	if err != nil {
-		// Removing from cache if file doesn't exist
-		delete(l.items, key)
-		l.queue.Remove(item)
+		// Removing from cache if file doesn't exist, unless the element has been replaced meanwhile
+		s.mutex.Lock()
+		current, exists := s.items[key]
+		if exists && current != item {
+			s.mutex.Unlock()
+			return l.get(ctx, key)
+		}
+		if exists {
+			l.forgetElement(s, item)
+		}
+		s.mutex.Unlock()
+		increment(&l.counters.misses)
+		increment(&l.counters.readErrors)
 
-		return []byte{}, fmt.Errorf("%w: %s", ErrFileRead, err)
+		return nil, fmt.Errorf("%w: %s", ErrFileRead, err)
 	}
 
-	// In success case move it to front
-	l.queue.MoveToFront(item)
+	// Items restored without index have no checksum, so they can't be verified
+	if sum := cacheItemElement.meta.Checksum; sum != "" && sum != checksum(value) {
+		s.mutex.Lock()
+		current, exists := s.items[key]
+		// Value read could belong to the element replaced meanwhile, so the new one is read instead
+		if exists && current != item {
+			s.mutex.Unlock()
+			return l.get(ctx, key)
+		}
+		if exists {
+			l.removeElement(s, item)
+		}
+		s.mutex.Unlock()
+		increment(&l.counters.misses)
+		increment(&l.counters.readErrors)
+
+		l.logger.Warn(fmt.Sprintf("corrupted cache file %s is evicted", cacheItemElement.value))
+
+		return nil, fmt.Errorf("%w: %s", ErrItemCorrupted, key)
+	}
+
+	increment(&l.counters.hits)
+
+	result := cacheItemElement.meta
+	result.Value = value
+
+	return &result, nil
+}
 
-	return value, nil
+// Set is a LruCache setter: sets or updates item, depends on whether the item exists or not.
+// Item with nil value updates metadata only, leaving the stored file untouched.
+func (l *LruCache) Set(key string, item *Item) error {
+	return l.SetContext(context.Background(), key, item)
 }
 
-// Set is a LruCache setter: sets or updates value, depends on whether the value exists or not.
-func (l *LruCache) Set(key string, imageBytes []byte) error {
-	l.mutex.Lock()
-	defer l.mutex.Unlock()
+// SetContext is Set traced within the trace of ctx.
+func (l *LruCache) SetContext(ctx context.Context, key string, item *Item) error {
+	ctx, span := startSpan(ctx, "cache.lru.Set", attribute.String("cache.key", key), attribute.Int("cache.bytes", len(item.Value)))
+	defer span.End()
 
-	listItem, exists := l.items[key]
+	s := l.shard(key)
 
-	filename := encodeFileName(key)
-	cacheItemElement := cacheItem{key, filename}
+	filename := filePath(key)
+	meta := *item
+	meta.Value = nil
 
-	if exists {
-		// If cache element exists, move it to front
-		listItem.Value = cacheItemElement
-		l.queue.MoveToFront(listItem)
-	} else {
-		// If cache element doesn't exist, create
-		listItem = l.queue.PushFront(cacheItemElement)
-
-		// If list exceeds capacity, remove last element from list and map
-		if int64(l.queue.Len()) > l.capacity {
-			l.removeLastRecentUsedElement()
+	// Value is written to a temporary file outside of the lock, and the file is moved in place under the lock
+	tmpFilename := ""
+	if item.Value != nil {
+		meta.Size = int64(len(item.Value))
+		meta.Checksum = checksum(item.Value)
+		if s.maxBytes > 0 && meta.Size > s.maxBytes {
+			return fmt.Errorf("%w: %d bytes", ErrItemTooLarge, meta.Size)
+		}
+
+		if meta.CreatedAt.IsZero() {
+			meta.CreatedAt = time.Now()
 		}
 
-		// Saving file to filesystem
-		// Nil value is used for restoring cache after launching
-		if imageBytes != nil {
-			err := l.saveToFileSystem(filename, imageBytes)
-			if err != nil {
-				l.logger.Error(fmt.Errorf("%w: %s", ErrFileWrite, err))
-			}
+		var err error
+		_, writeSpan := startSpan(ctx, "cache.lru.WriteFile")
+		tmpFilename, err = l.saveToTempFile(item.Value)
+		if err == nil {
+			// Subdirectories are never removed, so the one created here exists when the file is renamed
+			err = os.MkdirAll(filepath.Dir(filepath.Join(l.path, filename)), os.ModePerm)
 		}
+		internalTracing.End(writeSpan, err)
+		if err != nil {
+			l.logger.Error(fmt.Errorf("%w: %s", ErrFileWrite, err))
+			_ = os.Remove(tmpFilename)
+			return nil
+		}
+	}
+
+	// Items without explicit lifetime get the default one
+	if meta.ExpiresAt.IsZero() && l.ttl > 0 {
+		meta.ExpiresAt = time.Now().Add(l.ttl)
 	}
 
+	// Deferred calls run in reverse order, so the placed file and its index record are synced
+	// once the lock is released, but before the item is acknowledged
+	placed := false
+	var sequence uint64
+	defer func() {
+		if placed {
+			_, syncSpan := startSpan(ctx, "cache.lru.Sync")
+			l.syncPlaced(filename, sequence)
+			syncSpan.End()
+		}
+	}()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	listItem, exists := s.items[key]
+
+	// Metadata of an item evicted meanwhile has no file to describe
+	if tmpFilename == "" && !exists {
+		return nil
+	}
+
+	if tmpFilename != "" {
+		if err := os.Rename(tmpFilename, filepath.Join(l.path, filename)); err != nil {
+			l.logger.Error(fmt.Errorf("%w: %s", ErrFileWrite, err))
+			_ = os.Remove(tmpFilename)
+			return nil
+		}
+		placed = true
+	} else if exists {
+		// Metadata update keeps size and checksum of the stored file
+		meta.Size = listItem.Value.(cacheItem).meta.Size
+		meta.Checksum = listItem.Value.(cacheItem).meta.Checksum
+	}
+
+	cacheItemElement := cacheItem{key, filename, meta}
+
+	if exists {
+		// Replaced element gets a new list item, so that readers of the previous one can tell it's been replaced
+		s.bytes -= listItem.Value.(cacheItem).meta.Size
+		s.unindexSource(listItem.Value.(cacheItem))
+		s.queue.Remove(listItem)
+	}
+	listItem = s.queue.PushFront(cacheItemElement)
+
 	// Update map value anyway
-	l.items[key] = listItem
+	s.items[key] = listItem
+	s.bytes += meta.Size
+	s.indexSource(cacheItemElement)
+	sequence = l.record(journalRecord{Op: journalSet, Key: key, Item: &meta})
+	increment(&l.counters.sets)
+
+	// If shard exceeds capacity, remove last elements from list and map
+	for s.queue.Back() != listItem && s.isOverflowed() {
+		l.removeElement(s, s.queue.Back())
+		increment(&l.counters.evictions)
+	}
 
 	return nil
 }
 
-// removeLastRecentUsedElement removes LRU element from queue and file from filesystem.
-func (l *LruCache) removeLastRecentUsedElement() {
-	if item := l.queue.Back(); item != nil {
-		backCacheItem := item.Value.(cacheItem)
-		filename := backCacheItem.value
+// Remove removes item from cache and its file from filesystem.
+func (l *LruCache) Remove(key string) {
+	s := l.shard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
-		delete(l.items, backCacheItem.key)
-		l.queue.Remove(item)
+	l.tombstoneKey(s, key)
+	if item, exists := s.items[key]; exists {
+		l.removeElement(s, item)
+	}
+}
 
-		// Removing expired file from filesystem.
-		err := l.removeFromFileSystem(filename)
-		if err != nil {
-			l.logger.Error(fmt.Errorf("%w: %s", ErrFileRemove, err))
-		}
+// removeElement removes element from shard, and its file from filesystem.
+// Removing a file is a cheap metadata operation, so it is done under the shard lock
+// in order not to race with a newer file of the same key.
+func (l *LruCache) removeElement(s *shard, item *ListItem) {
+	l.forgetElement(s, item)
+
+	err := l.removeFromFileSystem(item.Value.(cacheItem).value)
+	if err != nil {
+		l.logger.Error(fmt.Errorf("%w: %s", ErrFileRemove, err))
 	}
 }
 
-// encodeFileName generates filename from key.
-func encodeFileName(key string) string {
-	return base64.StdEncoding.EncodeToString([]byte(key))
+// forgetElement removes element from shard and index, leaving filesystem untouched.
+func (l *LruCache) forgetElement(s *shard, item *ListItem) {
+	cacheItemElement := item.Value.(cacheItem)
+
+	s.removeElement(item)
+	l.record(journalRecord{Op: journalRemove, Key: cacheItemElement.key})
+}
+
+// syncPlaced syncs directory of the file renamed into place, so that the rename survives a system crash,
+// and the journal, so that the file isn't restored without its metadata and checksum.
+func (l *LruCache) syncPlaced(filename string, sequence uint64) {
+	if err := syncDir(filepath.Dir(filepath.Join(l.path, filename))); err != nil {
+		l.logger.Error(fmt.Errorf("%w: %s", ErrFileWrite, err))
+	}
+
+	if err := l.syncJournal(sequence); err != nil {
+		l.logger.Error(err)
+	}
 }
 
-// decodeFileName decodes filename from key.
-func decodeKey(key string) ([]byte, error) {
-	return base64.StdEncoding.DecodeString(key)
+// syncDir syncs directory entries.
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	err = dir.Sync()
+	if closeErr := dir.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
 }
 
-// saveToFileSystem writes file to filesystem.
-func (l *LruCache) saveToFileSystem(filename string, bytes []byte) error {
-	absFilename := filepath.Join(l.path, filename)
-	return ioutil.WriteFile(absFilename, bytes, 0o600)
+// checksum returns CRC-32 checksum of the value.
+func checksum(value []byte) string {
+	return fmt.Sprintf("%08x", crc32.Checksum(value, checksumTable))
+}
+
+// saveToTempFile writes bytes to a new temporary file of cache directory and returns its name.
+// File is synced, so that it's complete once renamed into place, even if the system crashes.
+func (l *LruCache) saveToTempFile(bytes []byte) (string, error) {
+	file, err := os.CreateTemp(l.tmpPath(), tmpFilePattern)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = file.Write(bytes)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return "", err
+	}
+
+	return file.Name(), nil
+}
+
+// CheckWritable writes and removes a probe file in cache directory.
+func (l *LruCache) CheckWritable(ctx context.Context) error {
+	filename, err := l.saveToTempFile([]byte("probe"))
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrFileWrite, err)
+	}
+
+	if err := os.Remove(filename); err != nil {
+		return fmt.Errorf("%w: %s", ErrFileRemove, err)
+	}
+
+	return nil
+}
+
+// tmpPath returns directory of files being written.
+func (l *LruCache) tmpPath() string {
+	return filepath.Join(l.path, tmpDirName)
 }
 
 // reads file from filesystem.
@@ -186,34 +504,17 @@ func (l *LruCache) removeFromFileSystem(filename string) error {
 
 // Clear re-init lruCache instance.
 func (l *LruCache) Clear() {
-	l.queue = NewList()
-	l.items = make(map[string]*ListItem, l.capacity)
-}
-
-func (l *LruCache) restoreFromFilesystem() error {
-	if _, err := os.Stat(l.path); os.IsNotExist(err) {
-		err := os.MkdirAll(l.path, os.ModePerm)
-		if err != nil {
-			return err
-		}
-	} else {
-		files, err := ioutil.ReadDir(l.path)
-		if err != nil {
-			return err
-		}
-
-		for _, f := range files {
-			name, err := decodeKey(f.Name())
-			if err != nil {
-				return err
-			}
-
-			err = l.Set(string(name), nil)
-			if err != nil {
-				return err
-			}
-		}
+	l.tombstoneSources(func(id string) bool {
+		return true
+	})
+
+	for _, s := range l.shards {
+		s.mutex.Lock()
+		s.clear()
+		s.mutex.Unlock()
 	}
 
-	return nil
+	if err := l.compactJournal(); err != nil {
+		l.logger.Error(err)
+	}
 }
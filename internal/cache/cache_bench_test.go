@@ -0,0 +1,74 @@
+//go:build bench
+// +build bench
+
+package cache
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"testing"
+
+	internallogger "github.com/spendmail/s3_previewer/internal/logger"
+)
+
+const (
+	benchItems     = 4096
+	benchValueSize = 16 * 1024
+)
+
+// Run with: go test -tags bench -bench . -benchtime 5s ./internal/cache
+func BenchmarkCacheParallel(b *testing.B) {
+	value := make([]byte, benchValueSize)
+
+	for _, shards := range []int{1, defaultShards} {
+		for _, writes := range []int{0, 10} {
+			name := fmt.Sprintf("shards=%d/writes=%d%%", shards, writes)
+			b.Run(name, func(b *testing.B) {
+				c := newBenchCache(b, shards)
+				defer c.Close()
+
+				for i := 0; i < benchItems; i++ {
+					_ = c.Set(strconv.Itoa(i), &Item{Value: value})
+				}
+
+				b.SetBytes(benchValueSize)
+				b.ResetTimer()
+
+				b.RunParallel(func(pb *testing.PB) {
+					r := rand.New(rand.NewSource(rand.Int63()))
+					for pb.Next() {
+						key := strconv.Itoa(r.Intn(benchItems))
+						if r.Intn(100) < writes {
+							_ = c.Set(key, &Item{Value: value})
+						} else {
+							_, _ = c.Get(key)
+						}
+					}
+				})
+			})
+		}
+	}
+}
+
+// newBenchCache creates cache large enough to hold all benchmark items.
+func newBenchCache(b *testing.B, shards int) *LruCache {
+	b.Helper()
+
+	config := newTestConfig(b)
+	config.Cache.Capacity = 2 * benchItems
+	config.Cache.Shards = shards
+
+	logger, err := internallogger.New(config)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	c, err := New(config, logger)
+	if err != nil {
+		b.Fatal(err)
+	}
+	<-c.Restored()
+
+	return c
+}
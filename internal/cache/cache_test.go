@@ -1,11 +1,18 @@
 package cache
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
 	"errors"
+	"io/fs"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"strconv"
 	"sync"
 	"testing"
+	"time"
 
 	internalconfig "github.com/spendmail/s3_previewer/internal/config"
 	internallogger "github.com/spendmail/s3_previewer/internal/logger"
@@ -54,13 +61,13 @@ func TestCache(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		err = c.Set("aaa", []byte("aaa"))
+		err = c.Set("aaa", &Item{Value: []byte("aaa")})
 		require.NoError(t, err)
 
-		err = c.Set("bbb", []byte("bbb"))
+		err = c.Set("bbb", &Item{Value: []byte("bbb")})
 		require.NoError(t, err)
 
-		err = c.Set("ccc", []byte("ccc"))
+		err = c.Set("ccc", &Item{Value: []byte("ccc")})
 		require.NoError(t, err)
 
 		_, err = c.Get("aaa")
@@ -68,11 +75,511 @@ func TestCache(t *testing.T) {
 
 		val, err := c.Get("bbb")
 		require.NoError(t, err)
-		require.Equal(t, []byte("bbb"), val)
+		require.Equal(t, []byte("bbb"), val.Value)
 
 		val, err = c.Get("ccc")
 		require.NoError(t, err)
-		require.Equal(t, []byte("ccc"), val)
+		require.Equal(t, []byte("ccc"), val.Value)
+	})
+
+	t.Run("cache byte capacity", func(t *testing.T) {
+		config := newTestConfig(t)
+		config.Cache.Capacity = 0
+		config.Cache.MaxBytes = 10
+
+		logger, err := internallogger.New(config)
+		require.NoError(t, err)
+
+		c, err := New(config, logger)
+		require.NoError(t, err)
+		defer c.Close()
+
+		require.NoError(t, c.Set("aaa", &Item{Value: []byte("aaaa")}))
+		require.NoError(t, c.Set("bbb", &Item{Value: []byte("bbbb")}))
+
+		// Touching aaa makes bbb the least recently used one.
+		_, err = c.Get("aaa")
+		require.NoError(t, err)
+
+		require.NoError(t, c.Set("ccc", &Item{Value: []byte("cccc")}))
+
+		_, err = c.Get("bbb")
+		require.Truef(t, errors.Is(err, ErrItemNotExists), "actual error %q", err)
+
+		val, err := c.Get("ccc")
+		require.NoError(t, err)
+		require.Equal(t, int64(4), val.Size)
+
+		// Growing existing item evicts others as well.
+		require.NoError(t, c.Set("ccc", &Item{Value: []byte("cccccccc")}))
+
+		_, err = c.Get("aaa")
+		require.Truef(t, errors.Is(err, ErrItemNotExists), "actual error %q", err)
+
+		err = c.Set("ddd", &Item{Value: []byte("ddddddddddd")})
+		require.Truef(t, errors.Is(err, ErrItemTooLarge), "actual error %q", err)
+
+		require.Len(t, dataFiles(t, config.Cache.Path), 1)
+	})
+
+	t.Run("item metadata", func(t *testing.T) {
+		config := newTestConfig(t)
+
+		logger, err := internallogger.New(config)
+		require.NoError(t, err)
+
+		c, err := New(config, logger)
+		require.NoError(t, err)
+		defer c.Close()
+
+		validatedAt := time.Now()
+		err = c.Set("aaa", &Item{Value: []byte("aaa"), SourceETag: `"v1"`, ValidatedAt: validatedAt})
+		require.NoError(t, err)
+
+		// Nil value updates metadata only.
+		err = c.Set("aaa", &Item{SourceETag: `"v2"`, ValidatedAt: validatedAt.Add(time.Minute)})
+		require.NoError(t, err)
+
+		val, err := c.Get("aaa")
+		require.NoError(t, err)
+		require.Equal(t, []byte("aaa"), val.Value)
+		require.Equal(t, `"v2"`, val.SourceETag)
+		require.Equal(t, validatedAt.Add(time.Minute), val.ValidatedAt)
+
+		c.Remove("aaa")
+		_, err = c.Get("aaa")
+		require.Truef(t, errors.Is(err, ErrItemNotExists), "actual error %q", err)
+
+		// Metadata of an evicted item is ignored.
+		require.NoError(t, c.Set("aaa", &Item{SourceETag: `"v3"`}))
+		_, err = c.Get("aaa")
+		require.Truef(t, errors.Is(err, ErrItemNotExists), "actual error %q", err)
+		require.Equal(t, int64(0), c.Stats().Entries)
+	})
+
+	t.Run("stored items are indexed at once", func(t *testing.T) {
+		config := newTestConfig(t)
+
+		logger, err := internallogger.New(config)
+		require.NoError(t, err)
+
+		c, err := New(config, logger)
+		require.NoError(t, err)
+		defer c.Close()
+
+		require.NoError(t, c.Set("aaa", &Item{Value: []byte("aaa")}))
+
+		// Journal record with the checksum is written before the item is acknowledged.
+		indexed, err := readJournal(filepath.Join(config.Cache.Path, journalFileName))
+		require.NoError(t, err)
+		require.Equal(t, checksum([]byte("aaa")), indexed.metas["aaa"].Checksum)
+	})
+
+	t.Run("corrupted items", func(t *testing.T) {
+		config := newTestConfig(t)
+
+		logger, err := internallogger.New(config)
+		require.NoError(t, err)
+
+		c, err := New(config, logger)
+		require.NoError(t, err)
+		defer c.Close()
+
+		require.NoError(t, c.Set("aaa", &Item{Value: []byte("complete value")}))
+
+		val, err := c.Get("aaa")
+		require.NoError(t, err)
+		require.Equal(t, checksum([]byte("complete value")), val.Checksum)
+
+		// Metadata update keeps checksum of the stored file.
+		require.NoError(t, c.Set("aaa", &Item{SourceETag: `"v2"`}))
+
+		// File truncated by a crash is evicted on read.
+		filename := filepath.Join(config.Cache.Path, filePath("aaa"))
+		require.NoError(t, os.WriteFile(filename, []byte("complete"), 0o600))
+
+		_, err = c.Get("aaa")
+		require.Truef(t, errors.Is(err, ErrItemCorrupted), "actual error %q", err)
+		require.NoFileExists(t, filename)
+
+		_, err = c.Get("aaa")
+		require.Truef(t, errors.Is(err, ErrItemNotExists), "actual error %q", err)
+
+		// Temporary files are cleaned up.
+		tmpFiles, err := os.ReadDir(c.tmpPath())
+		require.NoError(t, err)
+		require.Empty(t, tmpFiles)
+	})
+
+	t.Run("replacing items being read", func(t *testing.T) {
+		config := newTestConfig(t)
+
+		logger, err := internallogger.New(config)
+		require.NoError(t, err)
+
+		c, err := New(config, logger)
+		require.NoError(t, err)
+		defer c.Close()
+
+		values := [][]byte{[]byte("first value"), []byte("second value")}
+		require.NoError(t, c.Set("aaa", &Item{Value: values[0]}))
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 1; i <= 200; i++ {
+				require.NoError(t, c.Set("aaa", &Item{Value: values[i%2]}))
+			}
+		}()
+
+		// Value of the replaced item isn't taken for a corrupted one.
+		wg := sync.WaitGroup{}
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-done:
+						return
+					default:
+					}
+
+					val, err := c.Get("aaa")
+					require.NoError(t, err)
+					require.Contains(t, values, val.Value)
+					require.Equal(t, checksum(val.Value), val.Checksum)
+				}
+			}()
+		}
+		wg.Wait()
+
+		val, err := c.Get("aaa")
+		require.NoError(t, err)
+		require.Equal(t, values[0], val.Value)
+		require.Equal(t, int64(0), c.Stats().ReadErrors)
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		config := newTestConfig(t)
+
+		logger, err := internallogger.New(config)
+		require.NoError(t, err)
+
+		c, err := New(config, logger)
+		require.NoError(t, err)
+		defer c.Close()
+
+		require.NoError(t, c.Set("a1", &Item{Value: []byte("a1"), Bucket: "images", Key: "a/1.jpg"}))
+		require.NoError(t, c.Set("a2", &Item{Value: []byte("a2"), Bucket: "images", Key: "a/1.jpg"}))
+		require.NoError(t, c.Set("a3", &Item{Value: []byte("a3"), Bucket: "images", Key: "a/2.jpg"}))
+		require.NoError(t, c.Set("b1", &Item{Value: []byte("b1"), Bucket: "images", Key: "b/1.jpg"}))
+		require.NoError(t, c.Set("c1", &Item{Value: []byte("c1"), Bucket: "other", Key: "a/1.jpg"}))
+
+		require.Equal(t, 2, c.PurgeObject("images", "a/1.jpg"))
+		require.Equal(t, 0, c.PurgeObject("images", "a/1.jpg"))
+
+		_, err = c.Get("a1")
+		require.Truef(t, errors.Is(err, ErrItemNotExists), "actual error %q", err)
+
+		require.Equal(t, 1, c.PurgePrefix("images", "a/"))
+		require.Equal(t, 1, c.PurgePrefix("images", ""))
+
+		_, err = c.Get("c1")
+		require.NoError(t, err)
+
+		require.Len(t, dataFiles(t, config.Cache.Path), 1)
+	})
+
+	t.Run("restoring from index", func(t *testing.T) {
+		config := newTestConfig(t)
+		config.Cache.Capacity = 4
+
+		logger, err := internallogger.New(config)
+		require.NoError(t, err)
+
+		c, err := New(config, logger)
+		require.NoError(t, err)
+		defer c.Close()
+
+		createdAt := time.Now().Add(-time.Hour).Round(0)
+		require.NoError(t, c.Set("aaa", &Item{Value: []byte("aaa"), ContentType: "image/png", SourceETag: `"a"`, CreatedAt: createdAt}))
+		require.NoError(t, c.Set("bbb", &Item{Value: []byte("bbb")}))
+
+		// Touching aaa makes bbb the least recently used one.
+		_, err = c.Get("aaa")
+		require.NoError(t, err)
+
+		require.NoError(t, c.Set("ccc", &Item{Value: []byte("ccc")}))
+		require.NoError(t, c.Set("ddd", &Item{Value: []byte("ddd")}))
+		c.Remove("ddd")
+		c.Close()
+
+		c, err = New(config, logger)
+		require.NoError(t, err)
+		defer c.Close()
+		<-c.Restored()
+
+		_, err = c.Get("ddd")
+		require.Truef(t, errors.Is(err, ErrItemNotExists), "actual error %q", err)
+
+		// Restored order is ccc, aaa, bbb, so bbb is evicted first.
+		require.NoError(t, c.Set("eee", &Item{Value: []byte("eee")}))
+		require.NoError(t, c.Set("fff", &Item{Value: []byte("fff")}))
+
+		_, err = c.Get("bbb")
+		require.Truef(t, errors.Is(err, ErrItemNotExists), "actual error %q", err)
+
+		val, err := c.Get("aaa")
+		require.NoError(t, err)
+		require.Equal(t, []byte("aaa"), val.Value)
+		require.Equal(t, "image/png", val.ContentType)
+		require.Equal(t, `"a"`, val.SourceETag)
+		require.True(t, createdAt.Equal(val.CreatedAt))
+		require.Equal(t, int64(3), val.Size)
+	})
+
+	t.Run("background restoring", func(t *testing.T) {
+		config := newTestConfig(t)
+		config.Cache.Capacity = 3
+
+		logger, err := internallogger.New(config)
+		require.NoError(t, err)
+
+		require.NoError(t, os.MkdirAll(config.Cache.Path, os.ModePerm))
+		for _, key := range []string{"aaa", "bbb"} {
+			filename := filepath.Join(config.Cache.Path, filePath(key))
+			require.NoError(t, os.MkdirAll(filepath.Dir(filename), os.ModePerm))
+			require.NoError(t, os.WriteFile(filename, []byte(key), 0o600))
+		}
+
+		// Files of the flat layout are migrated to subdirectories.
+		err = os.WriteFile(filepath.Join(config.Cache.Path, base64.StdEncoding.EncodeToString([]byte("ccc"))), []byte("ccc"), 0o600)
+		require.NoError(t, err)
+		err = os.WriteFile(filepath.Join(config.Cache.Path, "not-a-key!"), []byte("garbage"), 0o600)
+		require.NoError(t, err)
+
+		// Files placed in wrong subdirectories are quarantined.
+		misplaced := filepath.Join(config.Cache.Path, "00", "00", encodeFileName("eee"))
+		require.NoError(t, os.MkdirAll(filepath.Dir(misplaced), os.ModePerm))
+		require.NoError(t, os.WriteFile(misplaced, []byte("eee"), 0o600))
+		misplacedAgain := filepath.Join(config.Cache.Path, "00", "01", encodeFileName("eee"))
+		require.NoError(t, os.MkdirAll(filepath.Dir(misplacedAgain), os.ModePerm))
+		require.NoError(t, os.WriteFile(misplacedAgain, []byte("eee again"), 0o600))
+
+		c, err := New(config, logger)
+		require.NoError(t, err)
+		defer c.Close()
+
+		// Item set during restoration is more recent than restored ones.
+		require.NoError(t, c.Set("ddd", &Item{Value: []byte("ddd")}))
+
+		<-c.Restored()
+
+		status := c.RestoreStatus()
+		require.True(t, status.Done)
+		require.Equal(t, 3, status.Quarantined)
+
+		val, err := c.Get("ddd")
+		require.NoError(t, err)
+		require.Equal(t, []byte("ddd"), val.Value)
+
+		require.Len(t, dataFiles(t, config.Cache.Path), 3)
+		_, err = os.Stat(filepath.Join(config.Cache.Path, quarantineDirName, "not-a-key!"))
+		require.NoError(t, err)
+		quarantined, err := os.ReadFile(filepath.Join(config.Cache.Path, quarantineDirName, "00", "00", encodeFileName("eee")))
+		require.NoError(t, err)
+		require.Equal(t, []byte("eee"), quarantined)
+		quarantined, err = os.ReadFile(filepath.Join(config.Cache.Path, quarantineDirName, "00", "01", encodeFileName("eee")))
+		require.NoError(t, err)
+		require.Equal(t, []byte("eee again"), quarantined)
+	})
+
+	t.Run("purging during restoration", func(t *testing.T) {
+		config := newTestConfig(t)
+
+		logger, err := internallogger.New(config)
+		require.NoError(t, err)
+
+		c, err := New(config, logger)
+		require.NoError(t, err)
+
+		require.NoError(t, c.Set("aaa", &Item{Value: []byte("aaa"), Bucket: "images", Key: "cat.jpg"}))
+		require.NoError(t, c.Set("bbb", &Item{Value: []byte("bbb"), Bucket: "images", Key: "dog.jpg"}))
+		require.NoError(t, c.Set("ccc", &Item{Value: []byte("ccc"), Bucket: "images", Key: "owl.jpg"}))
+		c.Close()
+
+		// File unknown to the index could be a variant of any source.
+		unindexed := filepath.Join(config.Cache.Path, filePath("ddd"))
+		require.NoError(t, os.MkdirAll(filepath.Dir(unindexed), os.ModePerm))
+		require.NoError(t, os.WriteFile(unindexed, []byte("ddd"), 0o600))
+
+		// Items are removed before restoration reaches them.
+		c, err = open(config, logger)
+		require.NoError(t, err)
+		require.Equal(t, 0, c.PurgeObject("images", "cat.jpg"))
+		c.Remove("bbb")
+		c.restoreFromFilesystem()
+
+		for _, key := range []string{"aaa", "bbb", "ddd"} {
+			_, err = c.Get(key)
+			require.Truef(t, errors.Is(err, ErrItemNotExists), "actual error %q", err)
+			require.NoFileExists(t, filepath.Join(config.Cache.Path, filePath(key)))
+		}
+
+		val, err := c.Get("ccc")
+		require.NoError(t, err)
+		require.Equal(t, []byte("ccc"), val.Value)
+
+		// Removals are finished, so tombstones don't affect newer items.
+		require.NoError(t, c.Set("aaa", &Item{Value: []byte("aaa"), Bucket: "images", Key: "cat.jpg"}))
+		_, err = c.Get("aaa")
+		require.NoError(t, err)
+		c.Close()
+	})
+
+	t.Run("restarting during restoration", func(t *testing.T) {
+		config := newTestConfig(t)
+
+		logger, err := internallogger.New(config)
+		require.NoError(t, err)
+
+		c, err := New(config, logger)
+		require.NoError(t, err)
+
+		require.NoError(t, c.Set("aaa", &Item{Value: []byte("aaa"), Bucket: "images", Key: "cat.jpg"}))
+		require.NoError(t, c.Set("bbb", &Item{Value: []byte("bbb"), Bucket: "images", Key: "dog.jpg"}))
+		c.Close()
+
+		// Restoration is interrupted, so the journal keeps items purged meanwhile.
+		c, err = open(config, logger)
+		require.NoError(t, err)
+		indexed, err := readJournal(filepath.Join(config.Cache.Path, restoreJournalFileName))
+		require.NoError(t, err)
+		c.setIndexed(indexed.metas)
+		require.Equal(t, 0, c.PurgeObject("images", "cat.jpg"))
+		c.Close()
+
+		c, err = New(config, logger)
+		require.NoError(t, err)
+		defer c.Close()
+		<-c.Restored()
+
+		_, err = c.Get("aaa")
+		require.Truef(t, errors.Is(err, ErrItemNotExists), "actual error %q", err)
+		_, err = c.Get("bbb")
+		require.NoError(t, err)
+	})
+
+	t.Run("index compaction", func(t *testing.T) {
+		config := newTestConfig(t)
+
+		logger, err := internallogger.New(config)
+		require.NoError(t, err)
+
+		c, err := New(config, logger)
+		require.NoError(t, err)
+		defer c.Close()
+
+		require.NoError(t, c.Set("aaa", &Item{Value: []byte("aaa"), SourceETag: `"a"`}))
+		for i := 0; i < 2*journalCompactMin; i++ {
+			_, err = c.Get("aaa")
+			require.NoError(t, err)
+		}
+		c.Close()
+
+		content, err := os.ReadFile(filepath.Join(config.Cache.Path, journalFileName))
+		require.NoError(t, err)
+		require.Less(t, bytes.Count(content, []byte("\n")), journalCompactMin)
+
+		c, err = New(config, logger)
+		require.NoError(t, err)
+		defer c.Close()
+		<-c.Restored()
+
+		val, err := c.Get("aaa")
+		require.NoError(t, err)
+		require.Equal(t, `"a"`, val.SourceETag)
+	})
+
+	t.Run("item expiration", func(t *testing.T) {
+		config := newTestConfig(t)
+		config.Cache.TTL = time.Hour
+
+		logger, err := internallogger.New(config)
+		require.NoError(t, err)
+
+		c, err := New(config, logger)
+		require.NoError(t, err)
+		defer c.Close()
+
+		err = c.Set("expired", &Item{Value: []byte("expired"), ExpiresAt: time.Now().Add(-time.Second)})
+		require.NoError(t, err)
+
+		err = c.Set("default", &Item{Value: []byte("default")})
+		require.NoError(t, err)
+
+		_, err = c.Get("expired")
+		require.Truef(t, errors.Is(err, ErrItemExpired), "actual error %q", err)
+
+		_, err = c.Get("expired")
+		require.Truef(t, errors.Is(err, ErrItemNotExists), "actual error %q", err)
+
+		val, err := c.Get("default")
+		require.NoError(t, err)
+		require.WithinDuration(t, time.Now().Add(time.Hour), val.ExpiresAt, time.Minute)
+	})
+
+	t.Run("janitor", func(t *testing.T) {
+		config := newTestConfig(t)
+		config.Cache.JanitorInterval = 10 * time.Millisecond
+
+		logger, err := internallogger.New(config)
+		require.NoError(t, err)
+
+		c, err := New(config, logger)
+		require.NoError(t, err)
+		defer c.Close()
+
+		err = c.Set("aaa", &Item{Value: []byte("aaa"), ExpiresAt: time.Now().Add(50 * time.Millisecond)})
+		require.NoError(t, err)
+
+		err = c.Set("bbb", &Item{Value: []byte("bbb")})
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool {
+			_, err := os.Stat(filepath.Join(config.Cache.Path, filePath("aaa")))
+			return errors.Is(err, os.ErrNotExist)
+		}, time.Second, 10*time.Millisecond)
+
+		_, err = c.Get("bbb")
+		require.NoError(t, err)
+	})
+
+	t.Run("readiness checks", func(t *testing.T) {
+		config := newTestConfig(t)
+
+		logger, err := internallogger.New(config)
+		require.NoError(t, err)
+
+		c, err := New(config, logger)
+		require.NoError(t, err)
+		defer c.Close()
+
+		<-c.Restored()
+		require.NoError(t, c.CheckRestored(context.Background()))
+		require.NoError(t, c.CheckWritable(context.Background()))
+
+		// Restoration progress is reported until it's finished.
+		restoring := &LruCache{restored: make(chan struct{}), status: RestoreStatus{Processed: 5, Restored: 3, Quarantined: 1}}
+		err = restoring.CheckRestored(context.Background())
+		require.ErrorIs(t, err, ErrRestoring)
+		require.Contains(t, err.Error(), "5 files processed, 3 restored, 1 quarantined")
+
+		// Probe file doesn't remain in cache directory.
+		entries, err := os.ReadDir(filepath.Join(config.Cache.Path, tmpDirName))
+		require.NoError(t, err)
+		require.Empty(t, entries)
 	})
 }
 
@@ -101,7 +608,7 @@ func TestCacheMultithreading(t *testing.T) {
 		defer wg.Done()
 		for i := 0; i < 1_000; i++ {
 			key := strconv.Itoa(i)
-			_ = c.Set(key, []byte(key))
+			_ = c.Set(key, &Item{Value: []byte(key)})
 		}
 	}()
 
@@ -115,3 +622,44 @@ func TestCacheMultithreading(t *testing.T) {
 
 	wg.Wait()
 }
+
+// newTestConfig builds a config with a cache directory private to the test.
+func newTestConfig(t testing.TB) *internalconfig.Config {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	return &internalconfig.Config{
+		Logger: internalconfig.LoggerConf{Level: "debug", File: filepath.Join(dir, "previewer.log")},
+		Cache:  internalconfig.CacheConf{Capacity: 10, Path: filepath.Join(dir, "cache")},
+	}
+}
+
+// dataFiles lists cached values of the cache subdirectories.
+func dataFiles(t *testing.T, path string) []string {
+	t.Helper()
+
+	var names []string
+	err := filepath.WalkDir(path, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if name != path && isServiceFile(entry.Name()) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	return names
+}
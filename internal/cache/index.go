@@ -0,0 +1,432 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	journalFileName = ".index.journal"
+	// Journal of the previous run is moved to this file until it is restored.
+	restoreJournalFileName = ".index.journal.restore"
+	journalSet             = "set"
+	journalTouch           = "touch"
+	journalRemove          = "remove"
+	// Buffered records are written to the file with this interval, so a crash loses only the latest touches
+	// and removals. Records of stored files are synced before the files are acknowledged.
+	journalFlushInterval = time.Second
+	// Journal is compacted when it contains more records than this factor multiplied by the number of items.
+	journalCompactFactor = 4
+	journalCompactMin    = 1024
+	// Touches of cache hits are queued for the journal writer, and dropped when the queue is full,
+	// as they only affect the LRU order restored after a restart.
+	journalTouchQueue = 4 * journalCompactMin
+)
+
+var (
+	ErrJournalRead  = errors.New("unable to read cache index")
+	ErrJournalWrite = errors.New("unable to write cache index")
+)
+
+// journal is an append-only log of cache index changes: it keeps items metadata and LRU order across restarts.
+type journal struct {
+	path    string
+	file    *os.File
+	writer  *bufio.Writer
+	records int
+	// Number of items at the moment of the latest compaction.
+	items int
+	// sequence is a number of the latest record, it keeps growing across compactions.
+	sequence uint64
+	// tail keeps copies of records written while the journal is being compacted.
+	tail   *bytes.Buffer
+	logger Logger
+}
+
+type journalRecord struct {
+	Op   string `json:"op"`
+	Key  string `json:"key"`
+	Item *Item  `json:"item,omitempty"`
+}
+
+// journalState is a replayed journal: metadata and keys ordered from the least to the most recently used.
+type journalState struct {
+	order []string
+	metas map[string]Item
+}
+
+// isServiceFile checks whether file of cache directory is not a cached value.
+func isServiceFile(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+// readJournal replays journal records. Missing journal means empty index, broken tail records are ignored.
+func readJournal(path string) (*journalState, error) {
+	state := &journalState{metas: make(map[string]Item)}
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrJournalRead, err)
+	}
+	defer file.Close()
+
+	queue := NewList()
+	elements := make(map[string]*ListItem)
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var record journalRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// Record could be partially written during a crash.
+			continue
+		}
+
+		element, exists := elements[record.Key]
+
+		switch record.Op {
+		case journalSet:
+			if record.Item == nil {
+				continue
+			}
+			state.metas[record.Key] = *record.Item
+			if exists {
+				queue.MoveToFront(element)
+			} else {
+				elements[record.Key] = queue.PushFront(record.Key)
+			}
+		case journalTouch:
+			if exists {
+				queue.MoveToFront(element)
+			}
+		case journalRemove:
+			if exists {
+				queue.Remove(element)
+				delete(elements, record.Key)
+				delete(state.metas, record.Key)
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrJournalRead, err)
+	}
+
+	state.order = make([]string, 0, queue.Len())
+	for element := queue.Back(); element != nil; element = element.Prev {
+		state.order = append(state.order, element.Value.(string))
+	}
+
+	return state, nil
+}
+
+// prepareJournal moves records of the previous run to the restore journal and opens an empty journal.
+// Restore journal left by an interrupted restoration is kept, with newer records appended to it.
+func prepareJournal(path, restorePath string, logger Logger) (*journal, error) {
+	content, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrJournalRead, err)
+	}
+
+	if len(content) > 0 {
+		restoreFile, err := os.OpenFile(restorePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrJournalWrite, err)
+		}
+
+		_, err = restoreFile.Write(content)
+		if closeErr := restoreFile.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrJournalWrite, err)
+		}
+	}
+
+	if err := os.Truncate(path, 0); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrJournalWrite, err)
+	}
+
+	return openJournal(path, logger)
+}
+
+// openJournal opens journal for appending.
+func openJournal(path string, logger Logger) (*journal, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrJournalWrite, err)
+	}
+
+	return &journal{
+		path:   path,
+		file:   file,
+		writer: bufio.NewWriter(file),
+		logger: logger,
+	}, nil
+}
+
+// record appends record to journal buffer and returns its sequence number, requesting compaction
+// when journal grows too large. Nil journal means closed cache.
+// Records are written under the lock of the shard the key belongs to, so that their order matches the shard one.
+func (l *LruCache) record(record journalRecord) uint64 {
+	l.journalMutex.Lock()
+	defer l.journalMutex.Unlock()
+
+	if l.journal == nil {
+		return 0
+	}
+
+	if err := l.journal.encode(record); err != nil {
+		l.logger.Error(err)
+	}
+
+	if l.journal.records > journalCompactMin+journalCompactFactor*l.journal.items {
+		select {
+		case l.compaction <- struct{}{}:
+		default:
+		}
+	}
+
+	return l.journal.sequence
+}
+
+// touch queues touch record of a cache hit, without waiting for the journal.
+func (l *LruCache) touch(key string) {
+	select {
+	case l.touches <- key:
+	default:
+	}
+}
+
+// recordTouches writes queued touch records.
+func (l *LruCache) recordTouches() {
+	for {
+		select {
+		case key := <-l.touches:
+			l.record(journalRecord{Op: journalTouch, Key: key})
+		default:
+			return
+		}
+	}
+}
+
+// encode writes a single record line.
+func (j *journal) encode(record journalRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrJournalWrite, err)
+	}
+
+	line = append(line, '\n')
+	if _, err := j.writer.Write(line); err != nil {
+		return fmt.Errorf("%w: %s", ErrJournalWrite, err)
+	}
+
+	if j.tail != nil {
+		j.tail.Write(line)
+	}
+
+	j.records++
+	j.sequence++
+
+	return nil
+}
+
+// flush writes buffered records to the file.
+func (j *journal) flush() error {
+	if err := j.writer.Flush(); err != nil {
+		return fmt.Errorf("%w: %s", ErrJournalWrite, err)
+	}
+
+	return nil
+}
+
+// close flushes buffered records and closes journal file.
+func (j *journal) close() error {
+	if j == nil {
+		return nil
+	}
+
+	err := j.flush()
+	if closeErr := j.file.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// flushJournal writes buffered journal records to the file.
+func (l *LruCache) flushJournal() error {
+	l.journalMutex.Lock()
+	defer l.journalMutex.Unlock()
+
+	if l.journal == nil {
+		return nil
+	}
+
+	return l.journal.flush()
+}
+
+// syncJournal makes records up to the sequence number durable. Records are written to the file under
+// the journal lock, but synced outside of it, so that a single sync commits records of concurrent callers.
+func (l *LruCache) syncJournal(sequence uint64) error {
+	l.syncMutex.Lock()
+	defer l.syncMutex.Unlock()
+
+	if l.synced >= sequence {
+		return nil
+	}
+
+	l.journalMutex.Lock()
+	if l.journal == nil {
+		l.journalMutex.Unlock()
+		return nil
+	}
+
+	err := l.journal.flush()
+	file, written := l.journal.file, l.journal.sequence
+	l.journalMutex.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	// Journal file isn't replaced or closed meanwhile, as both are done under the sync lock.
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("%w: %s", ErrJournalWrite, err)
+	}
+
+	l.synced = written
+
+	return nil
+}
+
+// compactJournal replaces journal with a snapshot of current items, written from the least to the most recently used.
+// Snapshot is taken under the locks of all shards, which are acquired before the journal one, as records are written
+// in this order. It's written once the locks are released, followed by the records written meanwhile.
+func (l *LruCache) compactJournal() error {
+	l.syncMutex.Lock()
+	defer l.syncMutex.Unlock()
+
+	records, items, current := l.snapshot()
+	if current == nil {
+		return nil
+	}
+
+	tmpPath := current.path + ".tmp"
+	compacted, err := l.writeSnapshot(tmpPath, records, items)
+	if compacted != nil {
+		compacted.path = current.path
+	}
+
+	l.journalMutex.Lock()
+	defer l.journalMutex.Unlock()
+
+	tail := current.tail
+	current.tail = nil
+
+	// Cache could be closed meanwhile.
+	if err == nil && l.journal != current {
+		err = fmt.Errorf("%w: journal is closed", ErrJournalWrite)
+	}
+
+	if err == nil {
+		if _, writeErr := compacted.writer.Write(tail.Bytes()); writeErr != nil {
+			err = fmt.Errorf("%w: %s", ErrJournalWrite, writeErr)
+		}
+		compacted.records += bytes.Count(tail.Bytes(), []byte("\n"))
+	}
+
+	if err == nil {
+		if renameErr := os.Rename(tmpPath, current.path); renameErr != nil {
+			err = fmt.Errorf("%w: %s", ErrJournalWrite, renameErr)
+		}
+	}
+
+	if err != nil {
+		if compacted != nil {
+			_ = compacted.file.Close()
+		}
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	// Snapshot file is opened for writing, so it becomes the journal itself. Records written to the previous
+	// journal are in the snapshot or its tail, so they are numbered alike.
+	compacted.sequence = current.sequence
+	_ = current.close()
+	l.journal = compacted
+
+	return nil
+}
+
+// snapshot returns records of current items and starts keeping copies of the records written after them.
+func (l *LruCache) snapshot() ([]journalRecord, int, *journal) {
+	for _, s := range l.shards {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+	}
+
+	l.journalMutex.Lock()
+	defer l.journalMutex.Unlock()
+
+	if l.journal == nil {
+		return nil, 0, nil
+	}
+
+	var records []journalRecord
+	items := 0
+	for _, s := range l.shards {
+		for item := s.queue.Back(); item != nil; item = item.Prev {
+			cacheItemElement := item.Value.(cacheItem)
+			meta := cacheItemElement.meta
+			records = append(records, journalRecord{Op: journalSet, Key: cacheItemElement.key, Item: &meta})
+		}
+		items += len(s.items)
+	}
+
+	l.journal.tail = &bytes.Buffer{}
+
+	return records, items, l.journal
+}
+
+// writeSnapshot writes records to a new journal file and syncs it, as it replaces the journal.
+func (l *LruCache) writeSnapshot(path string, records []journalRecord, items int) (*journal, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrJournalWrite, err)
+	}
+
+	compacted := &journal{path: path, file: file, writer: bufio.NewWriter(file), items: items, logger: l.logger}
+	for _, record := range records {
+		if err = compacted.encode(record); err != nil {
+			break
+		}
+	}
+
+	if err == nil {
+		err = compacted.flush()
+	}
+
+	if err == nil {
+		if syncErr := file.Sync(); syncErr != nil {
+			err = fmt.Errorf("%w: %s", ErrJournalWrite, syncErr)
+		}
+	}
+
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	return compacted, nil
+}
@@ -0,0 +1,112 @@
+package cache
+
+import (
+	"time"
+)
+
+// startJournalWriter launches background writing of touch records, flushing of cache index records
+// and its compaction, requested when the index grows too large.
+func (l *LruCache) startJournalWriter() {
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+
+		ticker := time.NewTicker(journalFlushInterval)
+		defer ticker.Stop()
+
+		for {
+			var err error
+
+			select {
+			case <-l.done:
+				return
+			case key := <-l.touches:
+				l.record(journalRecord{Op: journalTouch, Key: key})
+			case <-ticker.C:
+				err = l.flushJournal()
+			case <-l.compaction:
+				err = l.compactJournal()
+			}
+
+			if err != nil {
+				l.logger.Error(err)
+			}
+		}
+	}()
+}
+
+// startJanitor launches background removal of expired items. Non-positive interval disables it.
+func (l *LruCache) startJanitor(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-l.done:
+				return
+			case <-ticker.C:
+				if removed := l.RemoveExpired(); removed > 0 {
+					l.logger.Debug("cache janitor removed expired items: ", removed)
+				}
+			}
+		}
+	}()
+}
+
+// RemoveExpired removes all expired items and returns their count.
+func (l *LruCache) RemoveExpired() int {
+	now := time.Now()
+	removed := 0
+
+	for _, s := range l.shards {
+		s.mutex.Lock()
+		for _, item := range s.items {
+			if item.Value.(cacheItem).meta.IsExpired(now) {
+				l.removeElement(s, item)
+				increment(&l.counters.evictions)
+				removed++
+			}
+		}
+		s.mutex.Unlock()
+	}
+
+	return removed
+}
+
+// Close stops background goroutines and closes cache index.
+func (l *LruCache) Close() {
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
+	l.wg.Wait()
+	l.recordTouches()
+
+	// Journal is compacted on close, so that the next run replays a snapshot rather than all the records.
+	// Journal of an interrupted restoration is left as is, it's compacted once the restoration is done.
+	select {
+	case <-l.restored:
+		if err := l.compactJournal(); err != nil {
+			l.logger.Error(err)
+		}
+	default:
+	}
+
+	l.syncMutex.Lock()
+	defer l.syncMutex.Unlock()
+
+	l.journalMutex.Lock()
+	defer l.journalMutex.Unlock()
+
+	if err := l.journal.close(); err != nil {
+		l.logger.Error(err)
+	}
+	l.journal = nil
+}
@@ -0,0 +1,111 @@
+package cache
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Files are spread between 256 * 256 subdirectories, so that directories stay small for large caches.
+const fanOutLevelLength = 2
+
+// encodeFileName generates filename from key. URL alphabet contains neither "/" nor "+".
+func encodeFileName(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+// decodeKey decodes key from filename.
+func decodeKey(filename string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(filename)
+}
+
+// filePath returns path of the key file relative to cache directory: two levels of subdirectories
+// named after the key hash, and the encoded key itself.
+func filePath(key string) string {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(key))
+	dirs := hex.EncodeToString(hash.Sum(nil))
+
+	return filepath.Join(dirs[:fanOutLevelLength], dirs[fanOutLevelLength:2*fanOutLevelLength], encodeFileName(key))
+}
+
+// migrateFlatLayout moves files of the flat layout used by previous versions, named by standard base64 encoding,
+// into subdirectories. Files which names are not cache keys are moved to quarantine.
+func (l *LruCache) migrateFlatLayout() error {
+	dir, err := os.Open(l.path)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrRestore, err)
+	}
+	defer dir.Close()
+
+	migrated := 0
+	for {
+		if l.isClosed() {
+			return nil
+		}
+
+		entries, err := dir.ReadDir(restoreBatchSize)
+		for _, entry := range entries {
+			if entry.IsDir() || isServiceFile(entry.Name()) {
+				continue
+			}
+
+			key, err := base64.StdEncoding.DecodeString(entry.Name())
+			if err != nil {
+				l.quarantine(entry.Name())
+				continue
+			}
+
+			if err := l.migrateFile(entry.Name(), string(key)); err != nil {
+				l.logger.Warn(err)
+				continue
+			}
+			migrated++
+		}
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrRestore, err)
+		}
+	}
+
+	if migrated > 0 {
+		l.logger.Info(fmt.Sprintf("%d cache files are migrated to subdirectories", migrated))
+	}
+
+	return nil
+}
+
+// migrateFile moves a file of the flat layout to its subdirectory, unless the key has been set meanwhile.
+func (l *LruCache) migrateFile(filename, key string) error {
+	target := filepath.Join(l.path, filePath(key))
+	if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return fmt.Errorf("%w: %s", ErrFileWrite, err)
+	}
+
+	s := l.shard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	source := filepath.Join(l.path, filename)
+	if _, exists := s.items[key]; exists {
+		if err := os.Remove(source); err != nil {
+			return fmt.Errorf("%w: %s", ErrFileRemove, err)
+		}
+
+		return nil
+	}
+
+	if err := os.Rename(source, target); err != nil {
+		return fmt.Errorf("%w: %s", ErrFileWrite, err)
+	}
+
+	return nil
+}
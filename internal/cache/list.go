@@ -82,37 +82,22 @@ func (l *list) PushBack(v interface{}) *ListItem {
 
 // Remove removes element from the list and adjusts appropriate pointers.
 func (l *list) Remove(i *ListItem) {
-	// If item is only one element
-	if l.len == 1 {
-		l.front = nil
-		l.back = nil
-		i = nil
-		l.len--
-		return
-	}
-
-	// If item is front element
-	if i == l.front {
-		l.front = l.front.Next
-		l.front.Prev = nil
-		i = nil
-		l.len--
-		return
+	if i.Prev != nil {
+		i.Prev.Next = i.Next
+	} else {
+		// If item is front element
+		l.front = i.Next
 	}
 
-	// If item is back element
-	if i == l.back {
-		l.back.Prev = nil
-		l.back = nil
-		i = nil
-		l.len--
-		return
+	if i.Next != nil {
+		i.Next.Prev = i.Prev
+	} else {
+		// If item is back element
+		l.back = i.Prev
 	}
 
-	// If item is neither front nor back
-	i.Prev.Next = i.Next
-	i.Next.Prev = i.Prev
-	i = nil
+	i.Prev = nil
+	i.Next = nil
 	l.len--
 }
 
@@ -123,20 +108,18 @@ func (l *list) MoveToFront(i *ListItem) {
 		return
 	}
 
-	// If item is at the back
-	if i == l.back {
-		i.Prev.Next = nil
+	// Detaching item: it has previous element, because it isn't at the front
+	i.Prev.Next = i.Next
+	if i.Next != nil {
+		i.Next.Prev = i.Prev
+	} else {
+		// If item is at the back
 		l.back = i.Prev
-		i.Prev = nil
-		i.Next = l.front
-		l.front.Prev = i
-		l.front = i
-		return
 	}
 
-	// If item is neither at the front nor back
-	i.Prev.Next = i.Next
-	i.Next.Prev = i.Prev
+	// Attaching item to the front
+	i.Prev = nil
 	i.Next = l.front
 	l.front.Prev = i
+	l.front = i
 }
@@ -48,4 +48,39 @@ func TestList(t *testing.T) {
 		}
 		require.Equal(t, []int{70, 80, 60, 40, 10, 30, 50}, elems)
 	})
+
+	t.Run("remove and move", func(t *testing.T) {
+		l := NewList()
+
+		for _, v := range [...]int{10, 20, 30, 40} {
+			l.PushBack(v)
+		} // [10, 20, 30, 40]
+
+		l.Remove(l.Back()) // [10, 20, 30]
+		require.Equal(t, 3, l.Len())
+		require.Equal(t, 30, l.Back().Value)
+		require.Nil(t, l.Back().Next)
+
+		l.MoveToFront(l.Front().Next) // [20, 10, 30]
+		require.Equal(t, 20, l.Front().Value)
+		require.Nil(t, l.Front().Prev)
+
+		l.Remove(l.Front()) // [10, 30]
+		l.Remove(l.Back())  // [10]
+		require.Equal(t, 1, l.Len())
+		require.Equal(t, l.Front(), l.Back())
+
+		l.Remove(l.Front()) // []
+		require.Equal(t, 0, l.Len())
+		require.Nil(t, l.Front())
+		require.Nil(t, l.Back())
+
+		elems := make([]int, 0)
+		l.PushFront(50)
+		l.PushFront(60)
+		for i := l.Back(); i != nil; i = i.Prev {
+			elems = append(elems, i.Value.(int))
+		}
+		require.Equal(t, []int{50, 60}, elems)
+	})
 }
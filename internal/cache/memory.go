@@ -0,0 +1,286 @@
+package cache
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+type MemoryConfig interface {
+	GetCacheMemoryMaxBytes() int64
+	GetCacheTTL() time.Duration
+}
+
+// Tier is a cache the memory tier is put in front of.
+type Tier interface {
+	Set(key string, item *Item) error
+	Get(key string) (*Item, error)
+	Remove(key string)
+	PurgeObject(bucket, key string) int
+	PurgePrefix(bucket, prefix string) int
+	Clear()
+	Stats() Stats
+}
+
+// MemoryCache is a byte-limited in-memory LRU cache in front of another cache tier.
+// Items are written through to the next tier and kept in memory,
+// items read from the next tier are promoted into memory.
+type MemoryCache struct {
+	counters counters
+	mutex    sync.Mutex
+	maxBytes int64
+	bytes    int64
+	ttl      time.Duration
+	queue    List
+	items    map[string]*ListItem
+	next     Tier
+	logger   Logger
+	// Generation is changed by removals, so that items read or written before removal aren't put into memory.
+	generation uint64
+}
+
+type memoryItem struct {
+	key  string
+	item Item
+}
+
+// NewMemory is a memory cache constructor: returns MemoryCache instance pointer in front of the next tier.
+func NewMemory(config MemoryConfig, logger Logger, next Tier) *MemoryCache {
+	return &MemoryCache{
+		maxBytes: config.GetCacheMemoryMaxBytes(),
+		ttl:      config.GetCacheTTL(),
+		queue:    NewList(),
+		items:    make(map[string]*ListItem),
+		next:     next,
+		logger:   logger,
+	}
+}
+
+// Get returns item from memory, or promotes it from the next tier.
+// Returned value is shared with the cache, so it must not be modified.
+func (m *MemoryCache) Get(key string) (*Item, error) {
+	return m.GetContext(context.Background(), key)
+}
+
+// GetContext is Get passing ctx to the next tier, so that its operations are traced within the trace of ctx.
+func (m *MemoryCache) GetContext(ctx context.Context, key string) (*Item, error) {
+	m.mutex.Lock()
+
+	if listItem, exists := m.items[key]; exists {
+		element := listItem.Value.(memoryItem)
+
+		if !element.item.IsExpired(time.Now()) {
+			m.queue.MoveToFront(listItem)
+			m.mutex.Unlock()
+			increment(&m.counters.hits)
+
+			result := element.item
+
+			return &result, nil
+		}
+
+		// Expired items aren't returned, the next tier evicts them too.
+		m.removeElement(listItem)
+		increment(&m.counters.evictions)
+	}
+
+	generation := m.generation
+	m.mutex.Unlock()
+	increment(&m.counters.misses)
+
+	item, err := getTier(ctx, m.next, key)
+	if err != nil {
+		return nil, err
+	}
+
+	m.promote(key, *item, generation)
+
+	return item, nil
+}
+
+// Set writes item to the next tier and puts it into memory. Item with nil value updates metadata only.
+// Items exceeding memory size are set into the next tier only.
+func (m *MemoryCache) Set(key string, item *Item) error {
+	return m.SetContext(context.Background(), key, item)
+}
+
+// SetContext is Set passing ctx to the next tier, so that its operations are traced within the trace of ctx.
+func (m *MemoryCache) SetContext(ctx context.Context, key string, item *Item) error {
+	meta := *item
+
+	// Items without explicit lifetime get the default one, which is the same in both tiers
+	if meta.ExpiresAt.IsZero() && m.ttl > 0 {
+		meta.ExpiresAt = time.Now().Add(m.ttl)
+	}
+
+	if meta.Value == nil {
+		return m.setMeta(ctx, key, meta)
+	}
+
+	meta.Size = int64(len(meta.Value))
+	if meta.Size > m.maxBytes {
+		m.mutex.Lock()
+		if listItem, exists := m.items[key]; exists {
+			m.removeElement(listItem)
+		}
+		m.mutex.Unlock()
+
+		return setTier(ctx, m.next, key, &meta)
+	}
+
+	if meta.CreatedAt.IsZero() {
+		meta.CreatedAt = time.Now()
+	}
+
+	m.mutex.Lock()
+	generation := m.generation
+	m.mutex.Unlock()
+
+	// Memory keeps only items stored in the next tier, so that they survive restart
+	if err := setTier(ctx, m.next, key, &meta); err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	if generation != m.generation {
+		m.mutex.Unlock()
+		return nil
+	}
+
+	m.push(key, meta)
+	increment(&m.counters.sets)
+
+	return nil
+}
+
+// setMeta updates metadata of item in memory and in the next tier.
+// Items missing in the next tier are ignored by it.
+func (m *MemoryCache) setMeta(ctx context.Context, key string, meta Item) error {
+	m.mutex.Lock()
+
+	if listItem, exists := m.items[key]; exists {
+		element := listItem.Value.(memoryItem)
+		meta.Value = element.item.Value
+		meta.Size = element.item.Size
+		meta.CreatedAt = element.item.CreatedAt
+		element.item = meta
+		listItem.Value = element
+		m.queue.MoveToFront(listItem)
+	}
+
+	m.mutex.Unlock()
+
+	meta.Value = nil
+
+	return setTier(ctx, m.next, key, &meta)
+}
+
+// promote puts item read from the next tier into memory, unless it has been removed or set meanwhile.
+func (m *MemoryCache) promote(key string, item Item, generation uint64) {
+	if item.Size > m.maxBytes {
+		return
+	}
+
+	m.mutex.Lock()
+	if _, exists := m.items[key]; exists || generation != m.generation {
+		m.mutex.Unlock()
+		return
+	}
+
+	m.push(key, item)
+}
+
+// push puts item to the front of memory queue, evicts the least recently used items and releases the lock.
+// Evicted items are stored in the next tier, so they are just dropped.
+func (m *MemoryCache) push(key string, item Item) {
+	element := memoryItem{key, item}
+
+	if listItem, exists := m.items[key]; exists {
+		m.bytes -= listItem.Value.(memoryItem).item.Size
+		listItem.Value = element
+		m.queue.MoveToFront(listItem)
+	} else {
+		m.items[key] = m.queue.PushFront(element)
+	}
+	m.bytes += item.Size
+
+	for m.bytes > m.maxBytes && m.queue.Back() != m.items[key] {
+		m.removeElement(m.queue.Back())
+		increment(&m.counters.evictions)
+	}
+
+	m.mutex.Unlock()
+}
+
+// Remove removes item from both memory and the next tier.
+func (m *MemoryCache) Remove(key string) {
+	m.mutex.Lock()
+	if listItem, exists := m.items[key]; exists {
+		m.removeElement(listItem)
+	}
+	m.generation++
+	m.mutex.Unlock()
+
+	m.next.Remove(key)
+}
+
+// removeElement removes element from memory queue and map.
+func (m *MemoryCache) removeElement(listItem *ListItem) {
+	element := listItem.Value.(memoryItem)
+
+	delete(m.items, element.key)
+	m.queue.Remove(listItem)
+	m.bytes -= element.item.Size
+}
+
+// PurgeObject removes all cached variants of the source object from both tiers and returns their count.
+func (m *MemoryCache) PurgeObject(bucket, key string) int {
+	m.purge(func(item Item) bool {
+		return item.Bucket == bucket && item.Key == key
+	})
+
+	return m.next.PurgeObject(bucket, key)
+}
+
+// PurgePrefix removes all cached variants of source objects which keys start with the prefix
+// from both tiers and returns their count.
+func (m *MemoryCache) PurgePrefix(bucket, prefix string) int {
+	idPrefix := sourceID(bucket, prefix)
+
+	m.purge(func(item Item) bool {
+		return item.Bucket != "" && strings.HasPrefix(sourceID(item.Bucket, item.Key), idPrefix)
+	})
+
+	return m.next.PurgePrefix(bucket, prefix)
+}
+
+// purge removes memory items matching the filter. All of them are stored in the next tier,
+// so they are counted by the next tier.
+func (m *MemoryCache) purge(match func(item Item) bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.generation++
+	for listItem := m.queue.Front(); listItem != nil; {
+		next := listItem.Next
+
+		if match(listItem.Value.(memoryItem).item) {
+			m.removeElement(listItem)
+		}
+
+		listItem = next
+	}
+}
+
+// Clear re-init memory and the next tier.
+func (m *MemoryCache) Clear() {
+	m.mutex.Lock()
+	m.queue = NewList()
+	m.items = make(map[string]*ListItem)
+	m.bytes = 0
+	m.generation++
+	m.mutex.Unlock()
+
+	m.next.Clear()
+}
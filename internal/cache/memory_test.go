@@ -0,0 +1,147 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	internallogger "github.com/spendmail/s3_previewer/internal/logger"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryCache(t *testing.T) {
+	newTiers := func(t *testing.T) (*MemoryCache, *LruCache) {
+		t.Helper()
+
+		config := newTestConfig(t)
+		config.Cache.MemoryMaxBytes = 10
+
+		logger, err := internallogger.New(config)
+		require.NoError(t, err)
+
+		disk, err := New(config, logger)
+		require.NoError(t, err)
+		t.Cleanup(disk.Close)
+
+		return NewMemory(config, logger, disk), disk
+	}
+
+	t.Run("write through and promotion", func(t *testing.T) {
+		m, disk := newTiers(t)
+
+		require.NoError(t, m.Set("aaa", &Item{Value: []byte("aaaa")}))
+		require.NoError(t, m.Set("bbb", &Item{Value: []byte("bbbb")}))
+
+		// Items are written to disk at once
+		val, err := disk.Get("aaa")
+		require.NoError(t, err)
+		require.Equal(t, []byte("aaaa"), val.Value)
+		require.Contains(t, m.items, "aaa")
+
+		// The least recently used item is evicted from memory, and stays on disk
+		require.NoError(t, m.Set("ccc", &Item{Value: []byte("cccc")}))
+		require.NotContains(t, m.items, "aaa")
+
+		// Item read from disk is promoted, evicting the next least recently used one
+		val, err = m.Get("aaa")
+		require.NoError(t, err)
+		require.Equal(t, []byte("aaaa"), val.Value)
+		require.Contains(t, m.items, "aaa")
+		require.NotContains(t, m.items, "bbb")
+
+		val, err = disk.Get("bbb")
+		require.NoError(t, err)
+		require.Equal(t, []byte("bbbb"), val.Value)
+
+		_, err = m.Get("ddd")
+		require.ErrorIs(t, err, ErrItemNotExists)
+	})
+
+	t.Run("large items and metadata", func(t *testing.T) {
+		m, disk := newTiers(t)
+
+		require.NoError(t, m.Set("aaa", &Item{Value: []byte("too large for memory")}))
+		require.NotContains(t, m.items, "aaa")
+
+		val, err := disk.Get("aaa")
+		require.NoError(t, err)
+		require.Equal(t, []byte("too large for memory"), val.Value)
+
+		// Metadata update of item stored on disk reaches the disk
+		validatedAt := time.Now().Truncate(time.Second)
+		require.NoError(t, m.Set("aaa", &Item{ValidatedAt: validatedAt}))
+
+		val, err = disk.Get("aaa")
+		require.NoError(t, err)
+		require.True(t, validatedAt.Equal(val.ValidatedAt))
+		require.Equal(t, []byte("too large for memory"), val.Value)
+
+		// Metadata update of item stored in memory keeps its value
+		require.NoError(t, m.Set("bbb", &Item{Value: []byte("bbbb")}))
+		require.NoError(t, m.Set("bbb", &Item{ValidatedAt: validatedAt}))
+
+		val, err = m.Get("bbb")
+		require.NoError(t, err)
+		require.Equal(t, []byte("bbbb"), val.Value)
+		require.True(t, validatedAt.Equal(val.ValidatedAt))
+
+		val, err = disk.Get("bbb")
+		require.NoError(t, err)
+		require.Equal(t, []byte("bbbb"), val.Value)
+		require.True(t, validatedAt.Equal(val.ValidatedAt))
+
+		// Metadata update of evicted item is ignored by both tiers
+		require.NoError(t, m.Set("ccc", &Item{ValidatedAt: validatedAt}))
+		require.NotContains(t, m.items, "ccc")
+
+		_, err = disk.Get("ccc")
+		require.ErrorIs(t, err, ErrItemNotExists)
+	})
+
+	t.Run("purge and remove", func(t *testing.T) {
+		m, disk := newTiers(t)
+
+		require.NoError(t, m.Set("aaa", &Item{Value: []byte("aaaa"), Bucket: "images", Key: "cat.jpg"}))
+		require.NoError(t, m.Set("bbb", &Item{Value: []byte("bbbb"), Bucket: "images", Key: "cat.jpg"}))
+		require.NoError(t, m.Set("ccc", &Item{Value: []byte("cccc"), Bucket: "images", Key: "dog.jpg"}))
+
+		// Variants are counted once, though they are stored in both tiers
+		require.Equal(t, 2, m.PurgeObject("images", "cat.jpg"))
+
+		_, err := m.Get("aaa")
+		require.ErrorIs(t, err, ErrItemNotExists)
+		_, err = m.Get("bbb")
+		require.ErrorIs(t, err, ErrItemNotExists)
+
+		require.Equal(t, 1, m.PurgePrefix("images", "do"))
+
+		require.NoError(t, m.Set("ddd", &Item{Value: []byte("dddd")}))
+		m.Remove("ddd")
+		_, err = m.Get("ddd")
+		require.ErrorIs(t, err, ErrItemNotExists)
+		_, err = disk.Get("ddd")
+		require.ErrorIs(t, err, ErrItemNotExists)
+	})
+
+	t.Run("expiration", func(t *testing.T) {
+		m, disk := newTiers(t)
+
+		require.NoError(t, m.Set("aaa", &Item{Value: []byte("aaaa"), ExpiresAt: time.Now().Add(-time.Second)}))
+
+		// Expiration time is the same in both tiers, so the item is evicted from both
+		_, err := m.Get("aaa")
+		require.ErrorIs(t, err, ErrItemExpired)
+		require.NotContains(t, m.items, "aaa")
+
+		_, err = disk.Get("aaa")
+		require.ErrorIs(t, err, ErrItemNotExists)
+	})
+
+	t.Run("failed writes aren't kept in memory", func(t *testing.T) {
+		m, disk := newTiers(t)
+		disk.shards[0].maxBytes = 2
+
+		err := m.Set("aaa", &Item{Value: []byte("aaaa")})
+		require.ErrorIs(t, err, ErrItemTooLarge)
+		require.NotContains(t, m.items, "aaa")
+	})
+}
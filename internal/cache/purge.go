@@ -0,0 +1,81 @@
+package cache
+
+import (
+	"strings"
+)
+
+// sourceID builds reverse index key of a source object.
+func sourceID(bucket, key string) string {
+	return bucket + "/" + key
+}
+
+// indexSource registers cache key of the element as a variant of its source object.
+func (s *shard) indexSource(element cacheItem) {
+	if element.meta.Bucket == "" {
+		return
+	}
+
+	id := sourceID(element.meta.Bucket, element.meta.Key)
+	if s.sources[id] == nil {
+		s.sources[id] = make(map[string]struct{})
+	}
+	s.sources[id][element.key] = struct{}{}
+}
+
+// unindexSource removes cache key of the element from its source object variants.
+func (s *shard) unindexSource(element cacheItem) {
+	if element.meta.Bucket == "" {
+		return
+	}
+
+	id := sourceID(element.meta.Bucket, element.meta.Key)
+	delete(s.sources[id], element.key)
+	if len(s.sources[id]) == 0 {
+		delete(s.sources, id)
+	}
+}
+
+// PurgeObject removes all cached variants of the source object and returns their count.
+func (l *LruCache) PurgeObject(bucket, key string) int {
+	id := sourceID(bucket, key)
+
+	return l.purge(func(candidate string) bool {
+		return candidate == id
+	})
+}
+
+// PurgePrefix removes all cached variants of source objects which keys start with the prefix
+// and returns their count.
+func (l *LruCache) PurgePrefix(bucket, prefix string) int {
+	idPrefix := sourceID(bucket, prefix)
+
+	return l.purge(func(candidate string) bool {
+		return strings.HasPrefix(candidate, idPrefix)
+	})
+}
+
+// purge removes variants of all source objects matching the filter, shard by shard.
+func (l *LruCache) purge(match func(id string) bool) int {
+	l.tombstoneSources(match)
+
+	purged := 0
+
+	for _, s := range l.shards {
+		s.mutex.Lock()
+		for id, keys := range s.sources {
+			if !match(id) {
+				continue
+			}
+
+			for key := range keys {
+				if item, exists := s.items[key]; exists {
+					l.removeElement(s, item)
+					purged++
+				}
+			}
+		}
+		s.mutex.Unlock()
+	}
+
+	return purged
+}
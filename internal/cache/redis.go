@@ -0,0 +1,331 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	redisItemPrefix   = "item:"
+	redisSourcePrefix = "source:"
+	redisValueField   = "value"
+	redisMetaField    = "meta"
+	redisScanCount    = 1000
+)
+
+type RedisConfig interface {
+	GetCacheRedisAddress() string
+	GetCacheRedisPassword() string
+	GetCacheRedisDB() int
+	GetCacheRedisPrefix() string
+	GetCacheRedisMaxEntryBytes() int64
+	GetCacheTTL() time.Duration
+}
+
+// RedisCache is a cache shared by previewer replicas. Every item is a redis hash with the value and its metadata,
+// expired by redis itself. Variants of a source object are registered in a set, used for purging.
+type RedisCache struct {
+	counters      counters
+	client        *redis.Client
+	prefix        string
+	maxEntryBytes int64
+	ttl           time.Duration
+	logger        Logger
+}
+
+var ErrRedis = errors.New("redis request failed")
+
+// NewRedis is a redis cache constructor: returns RedisCache instance pointer, if redis is reachable.
+func NewRedis(config RedisConfig, logger Logger) (*RedisCache, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     config.GetCacheRedisAddress(),
+		Password: config.GetCacheRedisPassword(),
+		DB:       config.GetCacheRedisDB(),
+	})
+
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("%w: %s", ErrRedis, err)
+	}
+
+	return &RedisCache{
+		client:        client,
+		prefix:        config.GetCacheRedisPrefix(),
+		maxEntryBytes: config.GetCacheRedisMaxEntryBytes(),
+		ttl:           config.GetCacheTTL(),
+		logger:        logger,
+	}, nil
+}
+
+// Get returns item if exists, or error, if doesnt.
+func (r *RedisCache) Get(key string) (*Item, error) {
+	item, err := r.get(key)
+
+	switch {
+	case err == nil:
+		increment(&r.counters.hits)
+	case errors.Is(err, ErrItemNotExists), errors.Is(err, ErrItemExpired):
+		increment(&r.counters.misses)
+	default:
+		increment(&r.counters.misses)
+		increment(&r.counters.readErrors)
+	}
+
+	return item, err
+}
+
+// get reads item from redis.
+func (r *RedisCache) get(key string) (*Item, error) {
+	values, err := r.client.HMGet(context.Background(), r.itemKey(key), redisValueField, redisMetaField).Result()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrRedis, err)
+	}
+
+	value, valueOk := values[0].(string)
+	meta, metaOk := values[1].(string)
+	if !valueOk || !metaOk {
+		return nil, ErrItemNotExists
+	}
+
+	var item Item
+	if err := json.Unmarshal([]byte(meta), &item); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrFileRead, err)
+	}
+
+	// Redis expires items with second precision
+	if item.IsExpired(time.Now()) {
+		return nil, ErrItemExpired
+	}
+
+	item.Value = []byte(value)
+
+	return &item, nil
+}
+
+// Set sets or updates item. Item with nil value updates metadata of the existing item only.
+func (r *RedisCache) Set(key string, item *Item) error {
+	ctx := context.Background()
+	itemKey := r.itemKey(key)
+
+	meta := *item
+	meta.Value = nil
+
+	// Items without explicit lifetime get the default one
+	if meta.ExpiresAt.IsZero() && r.ttl > 0 {
+		meta.ExpiresAt = time.Now().Add(r.ttl)
+	}
+
+	fields := map[string]interface{}{}
+
+	if item.Value != nil {
+		meta.Size = int64(len(item.Value))
+		if r.maxEntryBytes > 0 && meta.Size > r.maxEntryBytes {
+			return fmt.Errorf("%w: %d bytes", ErrItemTooLarge, meta.Size)
+		}
+
+		if meta.CreatedAt.IsZero() {
+			meta.CreatedAt = time.Now()
+		}
+
+		fields[redisValueField] = item.Value
+	} else {
+		current, err := r.get(key)
+		if errors.Is(err, ErrItemNotExists) || errors.Is(err, ErrItemExpired) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// Metadata update keeps size of the stored value
+		meta.Size = current.Size
+		meta.CreatedAt = current.CreatedAt
+	}
+
+	encoded, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrFileWrite, err)
+	}
+	fields[redisMetaField] = encoded
+
+	sourceKey := r.sourceKey(meta.Bucket, meta.Key)
+	sourceTTL := time.Duration(0)
+	if meta.Bucket != "" {
+		sourceTTL, err = r.client.PTTL(ctx, sourceKey).Result()
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrRedis, err)
+		}
+	}
+
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, itemKey, fields)
+
+		if meta.ExpiresAt.IsZero() {
+			pipe.Persist(ctx, itemKey)
+		} else {
+			pipe.PExpireAt(ctx, itemKey, meta.ExpiresAt)
+		}
+
+		if meta.Bucket != "" {
+			pipe.SAdd(ctx, sourceKey, key)
+			r.extendSourceTTL(ctx, pipe, sourceKey, sourceTTL, meta.ExpiresAt)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrRedis, err)
+	}
+
+	increment(&r.counters.sets)
+
+	return nil
+}
+
+// extendSourceTTL makes source set live as long as its longest living variant, so that sets don't pile up.
+// Current TTL of the set is -2 when it doesn't exist, and -1 when it has variants without expiration.
+func (r *RedisCache) extendSourceTTL(
+	ctx context.Context, pipe redis.Pipeliner, sourceKey string, current time.Duration, expiresAt time.Time,
+) {
+	switch {
+	case expiresAt.IsZero():
+		pipe.Persist(ctx, sourceKey)
+	case current == -2, current >= 0 && current < time.Until(expiresAt):
+		pipe.PExpireAt(ctx, sourceKey, expiresAt)
+	}
+}
+
+// Remove removes item from cache.
+func (r *RedisCache) Remove(key string) {
+	ctx := context.Background()
+
+	item, err := r.get(key)
+	if err == nil && item.Bucket != "" {
+		r.client.SRem(ctx, r.sourceKey(item.Bucket, item.Key), key)
+	}
+
+	if err := r.client.Del(ctx, r.itemKey(key)).Err(); err != nil {
+		r.logger.Error(fmt.Errorf("%w: %s", ErrRedis, err))
+	}
+}
+
+// PurgeObject removes all cached variants of the source object and returns their count.
+func (r *RedisCache) PurgeObject(bucket, key string) int {
+	return r.purgeSource(r.sourceKey(bucket, key))
+}
+
+// PurgePrefix removes all cached variants of source objects which keys start with the prefix
+// and returns their count.
+func (r *RedisCache) PurgePrefix(bucket, prefix string) int {
+	purged := 0
+
+	err := r.scan(escapeGlob(r.sourceKey(bucket, prefix))+"*", func(sourceKey string) {
+		purged += r.purgeSource(sourceKey)
+	})
+	if err != nil {
+		r.logger.Error(err)
+	}
+
+	return purged
+}
+
+// purgeSource removes variants registered in the source set, and the set itself.
+// Set members of expired items are skipped, so only existing variants are counted.
+func (r *RedisCache) purgeSource(sourceKey string) int {
+	ctx := context.Background()
+
+	keys, err := r.client.SMembers(ctx, sourceKey).Result()
+	if err != nil {
+		r.logger.Error(fmt.Errorf("%w: %s", ErrRedis, err))
+		return 0
+	}
+
+	purged := 0
+	for _, key := range keys {
+		removed, err := r.client.Del(ctx, r.itemKey(key)).Result()
+		if err != nil {
+			r.logger.Error(fmt.Errorf("%w: %s", ErrRedis, err))
+			continue
+		}
+		purged += int(removed)
+	}
+
+	if err := r.client.Del(ctx, sourceKey).Err(); err != nil {
+		r.logger.Error(fmt.Errorf("%w: %s", ErrRedis, err))
+	}
+
+	return purged
+}
+
+// Clear removes all cache keys, leaving other keys of redis database untouched.
+func (r *RedisCache) Clear() {
+	ctx := context.Background()
+
+	err := r.scan(escapeGlob(r.prefix)+"*", func(key string) {
+		if err := r.client.Del(ctx, key).Err(); err != nil {
+			r.logger.Error(fmt.Errorf("%w: %s", ErrRedis, err))
+		}
+	})
+	if err != nil {
+		r.logger.Error(err)
+	}
+}
+
+// Ping checks that redis is reachable.
+func (r *RedisCache) Ping(ctx context.Context) error {
+	if err := r.client.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("%w: %s", ErrRedis, err)
+	}
+
+	return nil
+}
+
+// Close closes redis connections.
+func (r *RedisCache) Close() {
+	if err := r.client.Close(); err != nil {
+		r.logger.Error(fmt.Errorf("%w: %s", ErrRedis, err))
+	}
+}
+
+// scan calls fn for every key matching the pattern.
+func (r *RedisCache) scan(pattern string, fn func(key string)) error {
+	iterator := r.client.Scan(context.Background(), 0, pattern, redisScanCount).Iterator()
+	for iterator.Next(context.Background()) {
+		fn(iterator.Val())
+	}
+
+	if err := iterator.Err(); err != nil {
+		return fmt.Errorf("%w: %s", ErrRedis, err)
+	}
+
+	return nil
+}
+
+// itemKey builds redis key of cache item.
+func (r *RedisCache) itemKey(key string) string {
+	return r.prefix + redisItemPrefix + key
+}
+
+// sourceKey builds redis key of the set of source object variants.
+func (r *RedisCache) sourceKey(bucket, key string) string {
+	return r.prefix + redisSourcePrefix + sourceID(bucket, key)
+}
+
+// escapeGlob escapes special characters of redis glob pattern.
+func escapeGlob(s string) string {
+	var builder strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune(`*?[]^\`, c) {
+			builder.WriteRune('\\')
+		}
+		builder.WriteRune(c)
+	}
+
+	return builder.String()
+}
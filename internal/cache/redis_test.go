@@ -0,0 +1,111 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	internallogger "github.com/spendmail/s3_previewer/internal/logger"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisCache(t *testing.T) {
+	newRedisCache := func(t *testing.T) (*RedisCache, *miniredis.Miniredis) {
+		t.Helper()
+
+		server := miniredis.RunT(t)
+
+		config := newTestConfig(t)
+		config.Cache.TTL = time.Hour
+		config.Cache.Redis.Address = server.Addr()
+		config.Cache.Redis.Prefix = "previewer:"
+		config.Cache.Redis.MaxEntryBytes = 10
+
+		logger, err := internallogger.New(config)
+		require.NoError(t, err)
+
+		c, err := NewRedis(config, logger)
+		require.NoError(t, err)
+		t.Cleanup(c.Close)
+
+		return c, server
+	}
+
+	t.Run("set and get", func(t *testing.T) {
+		c, server := newRedisCache(t)
+
+		_, err := c.Get("aaa")
+		require.ErrorIs(t, err, ErrItemNotExists)
+
+		require.NoError(t, c.Set("aaa", &Item{Value: []byte("value"), ContentType: "image/png"}))
+
+		val, err := c.Get("aaa")
+		require.NoError(t, err)
+		require.Equal(t, []byte("value"), val.Value)
+		require.Equal(t, "image/png", val.ContentType)
+		require.Equal(t, int64(5), val.Size)
+
+		// Default lifetime is applied
+		require.InDelta(t, time.Hour, server.TTL("previewer:item:aaa"), float64(time.Second))
+
+		// Metadata update keeps the value
+		validatedAt := time.Now().Truncate(time.Second)
+		require.NoError(t, c.Set("aaa", &Item{ValidatedAt: validatedAt}))
+		val, err = c.Get("aaa")
+		require.NoError(t, err)
+		require.Equal(t, []byte("value"), val.Value)
+		require.True(t, validatedAt.Equal(val.ValidatedAt))
+
+		// Metadata update doesn't create items
+		require.NoError(t, c.Set("bbb", &Item{ValidatedAt: validatedAt}))
+		_, err = c.Get("bbb")
+		require.ErrorIs(t, err, ErrItemNotExists)
+
+		require.ErrorIs(t, c.Set("ccc", &Item{Value: []byte("too large value")}), ErrItemTooLarge)
+
+		c.Remove("aaa")
+		_, err = c.Get("aaa")
+		require.ErrorIs(t, err, ErrItemNotExists)
+	})
+
+	t.Run("expiration", func(t *testing.T) {
+		c, server := newRedisCache(t)
+
+		require.NoError(t, c.Set("aaa", &Item{Value: []byte("value"), ExpiresAt: time.Now().Add(time.Minute)}))
+		server.FastForward(2 * time.Minute)
+
+		_, err := c.Get("aaa")
+		require.ErrorIs(t, err, ErrItemNotExists)
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		c, server := newRedisCache(t)
+
+		require.NoError(t, c.Set("aaa", &Item{Value: []byte("a"), Bucket: "images", Key: "cats/1.jpg"}))
+		require.NoError(t, c.Set("bbb", &Item{
+			Value: []byte("b"), Bucket: "images", Key: "cats/1.jpg", ExpiresAt: time.Now().Add(2 * time.Hour),
+		}))
+		require.NoError(t, c.Set("ccc", &Item{Value: []byte("c"), Bucket: "images", Key: "cats/2.jpg"}))
+		require.NoError(t, c.Set("ddd", &Item{Value: []byte("d"), Bucket: "images", Key: "dogs/1.jpg"}))
+		require.NoError(t, c.Set("eee", &Item{Value: []byte("e"), Bucket: "images", Key: "c*"}))
+
+		// Source set lives as long as its longest living variant
+		require.InDelta(t, 2*time.Hour, server.TTL("previewer:source:images/cats/1.jpg"), float64(time.Second))
+
+		require.Equal(t, 2, c.PurgeObject("images", "cats/1.jpg"))
+		require.Equal(t, 0, c.PurgeObject("images", "cats/1.jpg"))
+
+		// Glob characters of prefix are matched literally
+		require.Equal(t, 1, c.PurgePrefix("images", "c*"))
+		require.Equal(t, 1, c.PurgePrefix("images", "cats/"))
+
+		_, err := c.Get("ddd")
+		require.NoError(t, err)
+
+		require.NoError(t, server.Set("foreign", "value"))
+		c.Clear()
+		_, err = c.Get("ddd")
+		require.ErrorIs(t, err, ErrItemNotExists)
+		require.True(t, server.Exists("foreign"))
+	})
+}
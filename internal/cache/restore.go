@@ -0,0 +1,390 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	quarantineDirName = ".quarantine"
+	restoreBatchSize  = 1000
+	// Restoration progress is logged every time this number of files is processed.
+	restoreProgressStep = 10000
+)
+
+var (
+	ErrRestore            = errors.New("unable to restore cache from filesystem")
+	ErrRestoring          = errors.New("cache is being restored from filesystem")
+	errRestoreInterrupted = errors.New("cache restoration is interrupted")
+)
+
+// RestoreStatus describes progress of restoring cache from filesystem.
+type RestoreStatus struct {
+	Done        bool `json:"done"`
+	Processed   int  `json:"processed"`
+	Restored    int  `json:"restored"`
+	Quarantined int  `json:"quarantined"`
+}
+
+// tombstones keeps keys and source objects removed while cache is being restored,
+// so that their files aren't restored afterwards.
+type tombstones struct {
+	mutex sync.Mutex
+	// indexed keeps metadata of the previous run, once its journal is read.
+	indexed map[string]Item
+	keys    map[string]struct{}
+	sources []func(id string) bool
+	done    bool
+}
+
+func newTombstones() *tombstones {
+	return &tombstones{keys: make(map[string]struct{})}
+}
+
+// covers checks whether item has been removed during restoration. Source of items unknown to the index
+// can't be checked, so they are considered removed by any purge.
+func (t *tombstones) covers(key string, meta Item) bool {
+	if _, exists := t.keys[key]; exists {
+		return true
+	}
+
+	if meta.Bucket == "" {
+		return len(t.sources) > 0
+	}
+
+	for _, match := range t.sources {
+		if match(sourceID(meta.Bucket, meta.Key)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// tombstoneKey keeps the key removed until restoration is finished. It's called under the shard lock.
+func (l *LruCache) tombstoneKey(s *shard, key string) {
+	l.tombstones.mutex.Lock()
+	if l.tombstones.done {
+		l.tombstones.mutex.Unlock()
+		return
+	}
+
+	l.tombstones.keys[key] = struct{}{}
+	_, indexed := l.tombstones.indexed[key]
+	l.tombstones.mutex.Unlock()
+
+	if indexed {
+		l.dropUnrestored(s, key)
+	}
+}
+
+// tombstoneSources keeps source objects matching the filter purged until restoration is finished.
+func (l *LruCache) tombstoneSources(match func(id string) bool) {
+	l.tombstones.mutex.Lock()
+	if l.tombstones.done {
+		l.tombstones.mutex.Unlock()
+		return
+	}
+
+	l.tombstones.sources = append(l.tombstones.sources, match)
+
+	var keys []string
+	for key, meta := range l.tombstones.indexed {
+		if meta.Bucket != "" && match(sourceID(meta.Bucket, meta.Key)) {
+			keys = append(keys, key)
+		}
+	}
+	l.tombstones.mutex.Unlock()
+
+	l.dropUnrestoredKeys(keys)
+}
+
+// setIndexed registers metadata of the previous run, dropping items removed before the journal was read.
+func (l *LruCache) setIndexed(indexed map[string]Item) {
+	l.tombstones.mutex.Lock()
+	l.tombstones.indexed = indexed
+
+	var keys []string
+	for key, meta := range indexed {
+		if l.tombstones.covers(key, meta) {
+			keys = append(keys, key)
+		}
+	}
+	l.tombstones.mutex.Unlock()
+
+	l.dropUnrestoredKeys(keys)
+}
+
+// dropUnrestoredKeys drops indexed items which haven't been restored yet, shard by shard.
+func (l *LruCache) dropUnrestoredKeys(keys []string) {
+	for _, key := range keys {
+		s := l.shard(key)
+		s.mutex.Lock()
+		l.dropUnrestored(s, key)
+		s.mutex.Unlock()
+	}
+}
+
+// dropUnrestored removes file of the indexed item which hasn't been restored yet, and records its removal,
+// so that it isn't restored after a restart either. It's called under the shard lock.
+func (l *LruCache) dropUnrestored(s *shard, key string) {
+	if _, exists := s.items[key]; exists {
+		return
+	}
+
+	l.record(journalRecord{Op: journalRemove, Key: key})
+	if err := l.removeFromFileSystem(filePath(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		l.logger.Error(fmt.Errorf("%w: %s", ErrFileRemove, err))
+	}
+}
+
+// clearTombstones releases tombstones once restoration is finished.
+func (l *LruCache) clearTombstones() {
+	l.tombstones.mutex.Lock()
+	defer l.tombstones.mutex.Unlock()
+
+	l.tombstones.done = true
+	l.tombstones.indexed = nil
+	l.tombstones.keys = nil
+	l.tombstones.sources = nil
+}
+
+// Restored returns a channel which is closed when cache restoration is finished.
+func (l *LruCache) Restored() <-chan struct{} {
+	return l.restored
+}
+
+// CheckRestored returns an error reporting restoration progress until cache restoration is finished.
+func (l *LruCache) CheckRestored(ctx context.Context) error {
+	select {
+	case <-l.restored:
+		return nil
+	default:
+		status := l.RestoreStatus()
+		return fmt.Errorf("%w: %d files processed, %d restored, %d quarantined",
+			ErrRestoring, status.Processed, status.Restored, status.Quarantined)
+	}
+}
+
+// RestoreStatus returns progress of restoring cache from filesystem.
+func (l *LruCache) RestoreStatus() RestoreStatus {
+	l.statusMutex.Lock()
+	defer l.statusMutex.Unlock()
+
+	return l.status
+}
+
+// restoreFromFilesystem registers files of cache directory in the order saved by the index.
+// Files unknown to the index are restored as the least recently used ones,
+// files which names are not cache keys are moved to quarantine.
+func (l *LruCache) restoreFromFilesystem() {
+	defer close(l.restored)
+	defer l.clearTombstones()
+
+	started := time.Now()
+	restoreJournalPath := filepath.Join(l.path, restoreJournalFileName)
+
+	indexed, err := readJournal(restoreJournalPath)
+	if err != nil {
+		l.logger.Error(err)
+		indexed = &journalState{metas: make(map[string]Item)}
+	}
+	l.setIndexed(indexed.metas)
+
+	if err := l.migrateFlatLayout(); err != nil {
+		l.logger.Error(err)
+	}
+
+	// Restored items are older than the ones set meanwhile, so they are appended from the most recently used one.
+	for i := len(indexed.order) - 1; i >= 0; i-- {
+		if l.isClosed() {
+			return
+		}
+
+		key := indexed.order[i]
+		info, err := os.Stat(filepath.Join(l.path, filePath(key)))
+		if err != nil {
+			continue
+		}
+
+		meta := indexed.metas[key]
+		meta.Size = info.Size()
+		l.restoreElement(key, meta)
+	}
+
+	if err := l.restoreUnindexed(indexed); err != nil {
+		l.logger.Error(err)
+	}
+
+	if l.isClosed() {
+		return
+	}
+
+	// Snapshot contains both restored and meanwhile set items, so records of the previous run aren't needed anymore.
+	if err := l.compactJournal(); err != nil {
+		l.logger.Error(err)
+	} else if err := os.Remove(restoreJournalPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		l.logger.Error(fmt.Errorf("%w: %s", ErrJournalWrite, err))
+	}
+
+	l.statusMutex.Lock()
+	defer l.statusMutex.Unlock()
+
+	l.status.Done = true
+	l.logger.Info(fmt.Sprintf(
+		"cache restored in %s: %d files processed, %d items restored, %d files quarantined",
+		time.Since(started), l.status.Processed, l.status.Restored, l.status.Quarantined,
+	))
+}
+
+// restoreUnindexed restores files of cache subdirectories which are missing in the index.
+// Files which names are not cache keys, or which are placed in wrong subdirectories, are moved to quarantine.
+func (l *LruCache) restoreUnindexed(indexed *journalState) error {
+	err := filepath.WalkDir(l.path, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if l.isClosed() {
+			return errRestoreInterrupted
+		}
+
+		relPath, err := filepath.Rel(l.path, path)
+		if err != nil {
+			return err
+		}
+
+		if relPath != "." && isServiceFile(entry.Name()) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		// Files left in cache directory itself are the flat layout ones, which couldn't be migrated
+		if entry.IsDir() || filepath.Dir(relPath) == "." {
+			return nil
+		}
+
+		name, err := decodeKey(entry.Name())
+		if err != nil || filePath(string(name)) != relPath {
+			l.quarantine(relPath)
+			return nil
+		}
+
+		key := string(name)
+		if _, exists := indexed.metas[key]; exists {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+
+		l.restoreElement(key, Item{Size: info.Size()})
+
+		return nil
+	})
+
+	if err != nil && !errors.Is(err, errRestoreInterrupted) {
+		return fmt.Errorf("%w: %s", ErrRestore, err)
+	}
+
+	return nil
+}
+
+// restoreElement appends item as the least recently used one, unless it has been set meanwhile.
+func (l *LruCache) restoreElement(key string, meta Item) {
+	restored := l.restoreToShard(key, meta)
+
+	l.statusMutex.Lock()
+	defer l.statusMutex.Unlock()
+
+	l.status.Processed++
+	if restored {
+		l.status.Restored++
+	}
+
+	if l.status.Processed%restoreProgressStep == 0 {
+		l.logger.Info(fmt.Sprintf("cache restoration progress: %d files processed", l.status.Processed))
+	}
+}
+
+// restoreToShard appends item to the back of its shard and reports whether the item is kept.
+func (l *LruCache) restoreToShard(key string, meta Item) bool {
+	s := l.shard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.items[key]; exists {
+		return false
+	}
+
+	// Item removed or purged during restoration is dropped together with its file.
+	l.tombstones.mutex.Lock()
+	removed := l.tombstones.covers(key, meta)
+	l.tombstones.mutex.Unlock()
+	if removed {
+		l.dropUnrestored(s, key)
+		return false
+	}
+
+	if meta.ExpiresAt.IsZero() && l.ttl > 0 {
+		meta.ExpiresAt = time.Now().Add(l.ttl)
+	}
+
+	cacheItemElement := cacheItem{key, filePath(key), meta}
+	listItem := s.queue.PushBack(cacheItemElement)
+	s.items[key] = listItem
+	s.bytes += meta.Size
+	s.indexSource(cacheItemElement)
+
+	// Shard is full, so the item itself is the one to be evicted.
+	if s.isOverflowed() {
+		l.removeElement(s, listItem)
+		increment(&l.counters.evictions)
+		return false
+	}
+
+	return true
+}
+
+// quarantine moves unrecognized file out of cache directory. Filename is relative to cache directory.
+func (l *LruCache) quarantine(filename string) {
+	l.statusMutex.Lock()
+	l.status.Processed++
+	l.status.Quarantined++
+	l.statusMutex.Unlock()
+
+	// Relative path is kept, so that files of the same name from different subdirectories don't overwrite each other.
+	quarantinePath := filepath.Join(l.path, quarantineDirName, filename)
+	err := os.MkdirAll(filepath.Dir(quarantinePath), os.ModePerm)
+	if err == nil {
+		err = os.Rename(filepath.Join(l.path, filename), quarantinePath)
+	}
+
+	if err != nil {
+		l.logger.Warn(fmt.Sprintf("unrecognized cache file %s is skipped: %s", filename, err))
+		return
+	}
+
+	l.logger.Warn(fmt.Sprintf("unrecognized cache file %s is moved to %s", filename, quarantinePath))
+}
+
+// isClosed checks whether cache is being closed.
+func (l *LruCache) isClosed() bool {
+	select {
+	case <-l.done:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,303 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	internalS3 "github.com/spendmail/s3_previewer/internal/s3"
+)
+
+const (
+	s3ItemsPrefix   = "items/"
+	s3SourcesPrefix = "sources/"
+
+	s3MetaSourceBucket       = "source-bucket"
+	s3MetaSourceKey          = "source-key"
+	s3MetaSourceETag         = "source-etag"
+	s3MetaSourceLastModified = "source-last-modified"
+	s3MetaValidatedAt        = "validated-at"
+	s3MetaExpiresAt          = "expires-at"
+	s3MetaStaleAt            = "stale-at"
+	s3MetaCreatedAt          = "created-at"
+	s3MetaLocation           = "location"
+)
+
+type S3Config interface {
+	GetCacheS3Bucket() string
+	GetCacheS3Prefix() string
+	GetCacheTTL() time.Duration
+}
+
+type S3Client interface {
+	Download(ctx context.Context, bucket, key string) (*internalS3.Object, error)
+	Head(ctx context.Context, bucket, key string) (*internalS3.Object, error)
+	Upload(ctx context.Context, bucket, key string, object *internalS3.Object) error
+	Remove(ctx context.Context, bucket, key string) error
+	List(ctx context.Context, bucket, prefix string) ([]string, error)
+}
+
+// S3Cache stores variants as objects of a cache bucket, with item metadata kept in object metadata.
+// Variants of a source object are registered by empty marker objects under its key, used for purging.
+// S3 doesn't expire objects itself, so expired variants are removed on read,
+// and a lifecycle rule of the cache bucket is expected to clean up the rest.
+type S3Cache struct {
+	counters counters
+	client   S3Client
+	bucket   string
+	prefix   string
+	ttl      time.Duration
+	logger   Logger
+}
+
+var (
+	ErrS3Cache       = errors.New("s3 cache request failed")
+	ErrS3CachePrefix = errors.New("s3 cache prefix is empty")
+)
+
+// NewS3 is a s3 cache constructor: returns S3Cache instance pointer.
+// Cache is cleared by removing all objects under its prefix, so empty prefix, which means the whole bucket, is refused.
+func NewS3(config S3Config, logger Logger, client S3Client) (*S3Cache, error) {
+	if config.GetCacheS3Prefix() == "" {
+		return nil, ErrS3CachePrefix
+	}
+
+	return &S3Cache{
+		client: client,
+		bucket: config.GetCacheS3Bucket(),
+		prefix: config.GetCacheS3Prefix(),
+		ttl:    config.GetCacheTTL(),
+		logger: logger,
+	}, nil
+}
+
+// Get returns item if exists, or error, if doesnt.
+func (c *S3Cache) Get(key string) (*Item, error) {
+	object, err := c.client.Download(context.Background(), c.bucket, c.itemKey(key))
+	if errors.Is(err, internalS3.ErrObjectNotFound) {
+		increment(&c.counters.misses)
+		return nil, ErrItemNotExists
+	}
+	if err != nil {
+		increment(&c.counters.misses)
+		increment(&c.counters.readErrors)
+
+		return nil, fmt.Errorf("%w: %s", ErrS3Cache, err)
+	}
+
+	item := decodeS3Item(object)
+	if item.IsExpired(time.Now()) {
+		c.Remove(key)
+		increment(&c.counters.misses)
+		increment(&c.counters.evictions)
+
+		return nil, ErrItemExpired
+	}
+
+	increment(&c.counters.hits)
+
+	return item, nil
+}
+
+// Set stores item. Object metadata can't be updated in place, so updates of metadata only are skipped:
+// the item is just revalidated once more after it's read back. Negative items are short-lived, so they are skipped too.
+func (c *S3Cache) Set(key string, item *Item) error {
+	if item.Value == nil || item.Failure != "" {
+		return nil
+	}
+
+	meta := *item
+
+	// Items without explicit lifetime get the default one
+	if meta.ExpiresAt.IsZero() && c.ttl > 0 {
+		meta.ExpiresAt = time.Now().Add(c.ttl)
+	}
+
+	if meta.CreatedAt.IsZero() {
+		meta.CreatedAt = time.Now()
+	}
+
+	ctx := context.Background()
+
+	if err := c.client.Upload(ctx, c.bucket, c.itemKey(key), encodeS3Item(&meta)); err != nil {
+		return fmt.Errorf("%w: %s", ErrS3Cache, err)
+	}
+
+	if meta.Bucket != "" {
+		marker := &internalS3.Object{Body: []byte{}}
+		if err := c.client.Upload(ctx, c.bucket, c.markerKey(meta.Bucket, meta.Key, key), marker); err != nil {
+			return fmt.Errorf("%w: %s", ErrS3Cache, err)
+		}
+	}
+
+	increment(&c.counters.sets)
+
+	return nil
+}
+
+// Remove removes item and its marker from cache bucket.
+func (c *S3Cache) Remove(key string) {
+	ctx := context.Background()
+
+	object, err := c.client.Head(ctx, c.bucket, c.itemKey(key))
+	if errors.Is(err, internalS3.ErrObjectNotFound) {
+		return
+	}
+	if err != nil {
+		c.logger.Error(fmt.Errorf("%w: %s", ErrS3Cache, err))
+		return
+	}
+
+	if item := decodeS3Item(object); item.Bucket != "" {
+		c.remove(ctx, c.markerKey(item.Bucket, item.Key, key))
+	}
+
+	c.remove(ctx, c.itemKey(key))
+}
+
+// PurgeObject removes all cached variants of the source object and returns their count.
+func (c *S3Cache) PurgeObject(bucket, key string) int {
+	return c.purge(c.sourceKey(bucket, key)+"/", true)
+}
+
+// PurgePrefix removes all cached variants of source objects which keys start with the prefix
+// and returns their count.
+func (c *S3Cache) PurgePrefix(bucket, prefix string) int {
+	return c.purge(c.sourceKey(bucket, prefix), false)
+}
+
+// purge removes variants which markers start with the prefix.
+// Exact purge skips markers of nested source keys, which share the prefix.
+func (c *S3Cache) purge(markerPrefix string, exact bool) int {
+	ctx := context.Background()
+
+	markers, err := c.client.List(ctx, c.bucket, markerPrefix)
+	if err != nil {
+		c.logger.Error(fmt.Errorf("%w: %s", ErrS3Cache, err))
+		return 0
+	}
+
+	purged := 0
+	for _, marker := range markers {
+		key := marker[strings.LastIndex(marker, "/")+1:]
+		if exact && strings.Contains(strings.TrimPrefix(marker, markerPrefix), "/") {
+			continue
+		}
+
+		if c.remove(ctx, c.itemKey(key)) {
+			purged++
+		}
+		c.remove(ctx, marker)
+	}
+
+	return purged
+}
+
+// Clear removes all objects of cache prefix.
+func (c *S3Cache) Clear() {
+	ctx := context.Background()
+
+	keys, err := c.client.List(ctx, c.bucket, c.prefix)
+	if err != nil {
+		c.logger.Error(fmt.Errorf("%w: %s", ErrS3Cache, err))
+		return
+	}
+
+	for _, key := range keys {
+		c.remove(ctx, key)
+	}
+}
+
+// remove deletes an object and reports whether it has succeeded.
+func (c *S3Cache) remove(ctx context.Context, objectKey string) bool {
+	if err := c.client.Remove(ctx, c.bucket, objectKey); err != nil {
+		c.logger.Error(fmt.Errorf("%w: %s", ErrS3Cache, err))
+		return false
+	}
+
+	return true
+}
+
+// itemKey builds object key of cache item.
+func (c *S3Cache) itemKey(key string) string {
+	return c.prefix + s3ItemsPrefix + key
+}
+
+// sourceKey builds common prefix of source object markers.
+func (c *S3Cache) sourceKey(bucket, key string) string {
+	return c.prefix + s3SourcesPrefix + sourceID(bucket, key)
+}
+
+// markerKey builds object key of a marker registering cache item as a variant of the source object.
+func (c *S3Cache) markerKey(bucket, sourceKey, key string) string {
+	return c.sourceKey(bucket, sourceKey) + "/" + key
+}
+
+// encodeS3Item converts item into object. Metadata is sent in headers, so values are kept ASCII.
+func encodeS3Item(item *Item) *internalS3.Object {
+	metadata := map[string]string{
+		s3MetaSourceETag:         url.QueryEscape(item.SourceETag),
+		s3MetaSourceLastModified: formatS3Time(item.LastModified),
+		s3MetaValidatedAt:        formatS3Time(item.ValidatedAt),
+		s3MetaExpiresAt:          formatS3Time(item.ExpiresAt),
+		s3MetaStaleAt:            formatS3Time(item.StaleAt),
+		s3MetaCreatedAt:          formatS3Time(item.CreatedAt),
+		s3MetaLocation:           url.QueryEscape(item.Location),
+	}
+
+	if item.Bucket != "" {
+		metadata[s3MetaSourceBucket] = item.Bucket
+		metadata[s3MetaSourceKey] = url.QueryEscape(item.Key)
+	}
+
+	return &internalS3.Object{
+		Body:        item.Value,
+		ContentType: item.ContentType,
+		Metadata:    metadata,
+	}
+}
+
+// decodeS3Item converts object into item. Broken metadata values are left empty.
+func decodeS3Item(object *internalS3.Object) *Item {
+	unescape := func(name string) string {
+		value, _ := url.QueryUnescape(object.Metadata[name])
+		return value
+	}
+
+	return &Item{
+		Value:        object.Body,
+		Bucket:       object.Metadata[s3MetaSourceBucket],
+		Key:          unescape(s3MetaSourceKey),
+		ContentType:  object.ContentType,
+		SourceETag:   unescape(s3MetaSourceETag),
+		LastModified: parseS3Time(object.Metadata[s3MetaSourceLastModified]),
+		ValidatedAt:  parseS3Time(object.Metadata[s3MetaValidatedAt]),
+		ExpiresAt:    parseS3Time(object.Metadata[s3MetaExpiresAt]),
+		StaleAt:      parseS3Time(object.Metadata[s3MetaStaleAt]),
+		CreatedAt:    parseS3Time(object.Metadata[s3MetaCreatedAt]),
+		Location:     unescape(s3MetaLocation),
+		Size:         int64(len(object.Body)),
+	}
+}
+
+// formatS3Time formats time for object metadata. Zero time is an empty value.
+func formatS3Time(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// parseS3Time parses time of object metadata. Empty or broken value is zero time.
+func parseS3Time(value string) time.Time {
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return t
+}
@@ -0,0 +1,194 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	internallogger "github.com/spendmail/s3_previewer/internal/logger"
+	internalS3 "github.com/spendmail/s3_previewer/internal/s3"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeS3Client keeps objects in memory.
+type fakeS3Client struct {
+	mutex   sync.Mutex
+	objects map[string]internalS3.Object
+}
+
+func newFakeS3Client() *fakeS3Client {
+	return &fakeS3Client{objects: make(map[string]internalS3.Object)}
+}
+
+func (c *fakeS3Client) Download(_ context.Context, bucket, key string) (*internalS3.Object, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	object, exists := c.objects[bucket+"/"+key]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", internalS3.ErrObjectNotFound, key)
+	}
+
+	return &object, nil
+}
+
+func (c *fakeS3Client) Head(ctx context.Context, bucket, key string) (*internalS3.Object, error) {
+	object, err := c.Download(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	object.Body = nil
+
+	return object, nil
+}
+
+func (c *fakeS3Client) Upload(_ context.Context, bucket, key string, object *internalS3.Object) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.objects[bucket+"/"+key] = *object
+
+	return nil
+}
+
+func (c *fakeS3Client) Remove(_ context.Context, bucket, key string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.objects, bucket+"/"+key)
+
+	return nil
+}
+
+func (c *fakeS3Client) List(_ context.Context, bucket, prefix string) ([]string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var keys []string
+	for name := range c.objects {
+		if strings.HasPrefix(name, bucket+"/"+prefix) {
+			keys = append(keys, strings.TrimPrefix(name, bucket+"/"))
+		}
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+func TestS3Cache(t *testing.T) {
+	newS3Cache := func(t *testing.T) (*S3Cache, *fakeS3Client) {
+		t.Helper()
+
+		config := newTestConfig(t)
+		config.Cache.TTL = time.Hour
+		config.Cache.S3.Bucket = "cache"
+		config.Cache.S3.Prefix = "previewer/"
+
+		logger, err := internallogger.New(config)
+		require.NoError(t, err)
+
+		client := newFakeS3Client()
+		c, err := NewS3(config, logger, client)
+		require.NoError(t, err)
+
+		return c, client
+	}
+
+	t.Run("empty prefix", func(t *testing.T) {
+		config := newTestConfig(t)
+		config.Cache.S3.Bucket = "cache"
+
+		logger, err := internallogger.New(config)
+		require.NoError(t, err)
+
+		// Clearing cache without a prefix would remove the whole bucket.
+		_, err = NewS3(config, logger, newFakeS3Client())
+		require.ErrorIs(t, err, ErrS3CachePrefix)
+	})
+
+	t.Run("set and get", func(t *testing.T) {
+		c, client := newS3Cache(t)
+
+		_, err := c.Get("aaa")
+		require.ErrorIs(t, err, ErrItemNotExists)
+
+		lastModified := time.Date(2022, 7, 1, 12, 0, 0, 0, time.UTC)
+		staleAt := time.Now().Add(time.Minute).Truncate(time.Second)
+		require.NoError(t, c.Set("aaa", &Item{
+			Value:        []byte("value"),
+			Bucket:       "images",
+			Key:          "котики/1.jpg",
+			ContentType:  "image/jpeg",
+			SourceETag:   `"etag"`,
+			LastModified: lastModified,
+			StaleAt:      staleAt,
+		}))
+
+		object := client.objects["cache/previewer/items/aaa"]
+		require.Equal(t, "image/jpeg", object.ContentType)
+		require.Contains(t, client.objects, "cache/previewer/sources/images/котики/1.jpg/aaa")
+
+		val, err := c.Get("aaa")
+		require.NoError(t, err)
+		require.Equal(t, []byte("value"), val.Value)
+		require.Equal(t, "images", val.Bucket)
+		require.Equal(t, "котики/1.jpg", val.Key)
+		require.Equal(t, "image/jpeg", val.ContentType)
+		require.Equal(t, `"etag"`, val.SourceETag)
+		require.True(t, lastModified.Equal(val.LastModified))
+		require.True(t, staleAt.Equal(val.StaleAt))
+		require.False(t, val.ExpiresAt.IsZero())
+		require.Equal(t, int64(5), val.Size)
+
+		// Metadata updates are skipped
+		require.NoError(t, c.Set("aaa", &Item{ValidatedAt: time.Now()}))
+		val, err = c.Get("aaa")
+		require.NoError(t, err)
+		require.True(t, val.ValidatedAt.IsZero())
+
+		c.Remove("aaa")
+		require.Empty(t, client.objects)
+	})
+
+	t.Run("negative items are skipped", func(t *testing.T) {
+		c, client := newS3Cache(t)
+
+		require.NoError(t, c.Set("aaa", &Item{Value: []byte{}, Bucket: "images", Key: "1.jpg", Failure: "not-found"}))
+		require.Empty(t, client.objects)
+	})
+
+	t.Run("expiration", func(t *testing.T) {
+		c, client := newS3Cache(t)
+
+		require.NoError(t, c.Set("aaa", &Item{Value: []byte("value"), ExpiresAt: time.Now().Add(-time.Second)}))
+
+		_, err := c.Get("aaa")
+		require.ErrorIs(t, err, ErrItemExpired)
+		require.Empty(t, client.objects)
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		c, client := newS3Cache(t)
+
+		require.NoError(t, c.Set("aaa", &Item{Value: []byte("a"), Bucket: "images", Key: "cats/1.jpg"}))
+		require.NoError(t, c.Set("bbb", &Item{Value: []byte("b"), Bucket: "images", Key: "cats/1.jpg"}))
+		require.NoError(t, c.Set("ccc", &Item{Value: []byte("c"), Bucket: "images", Key: "cats/1.jpg/2.jpg"}))
+		require.NoError(t, c.Set("ddd", &Item{Value: []byte("d"), Bucket: "images", Key: "dogs/1.jpg"}))
+
+		// Variants of nested source keys are left
+		require.Equal(t, 2, c.PurgeObject("images", "cats/1.jpg"))
+		_, err := c.Get("ccc")
+		require.NoError(t, err)
+
+		require.Equal(t, 1, c.PurgePrefix("images", "cats/"))
+		_, err = c.Get("ddd")
+		require.NoError(t, err)
+
+		c.Clear()
+		require.Empty(t, client.objects)
+	})
+}
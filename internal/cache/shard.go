@@ -0,0 +1,99 @@
+package cache
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+const (
+	defaultShards = 16
+	// Small caches are split into fewer shards, so that their LRU order stays close to the global one.
+	minShardCapacity = 64
+	minShardBytes    = 16 << 20
+)
+
+// shard is a part of cache index with its own lock, LRU queue and limits.
+type shard struct {
+	mutex    sync.Mutex
+	capacity int64
+	maxBytes int64
+	bytes    int64
+	queue    List
+	items    map[string]*ListItem
+	sources  map[string]map[string]struct{}
+}
+
+// newShards splits cache limits between shards. Non-positive shards count means the default one.
+func newShards(count int, capacity, maxBytes int64) []*shard {
+	if count <= 0 {
+		count = defaultShards
+	}
+
+	if capacity > 0 && int64(count) > capacity/minShardCapacity {
+		count = int(capacity / minShardCapacity)
+	}
+
+	if maxBytes > 0 && int64(count) > maxBytes/minShardBytes {
+		count = int(maxBytes / minShardBytes)
+	}
+
+	if count < 1 {
+		count = 1
+	}
+
+	shards := make([]*shard, count)
+	for i := range shards {
+		shards[i] = &shard{
+			capacity: divideLimit(capacity, count),
+			maxBytes: divideLimit(maxBytes, count),
+		}
+		shards[i].clear()
+	}
+
+	return shards
+}
+
+// divideLimit splits limit between shards rounding up. Non-positive limit means no limit.
+func divideLimit(limit int64, count int) int64 {
+	if limit <= 0 {
+		return 0
+	}
+
+	return (limit + int64(count) - 1) / int64(count)
+}
+
+// shard returns a shard the key belongs to.
+func (l *LruCache) shard(key string) *shard {
+	if len(l.shards) == 1 {
+		return l.shards[0]
+	}
+
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(key))
+
+	return l.shards[hash.Sum32()%uint32(len(l.shards))]
+}
+
+// isOverflowed checks whether shard exceeds any of its limits. Non-positive limit means no limit.
+func (s *shard) isOverflowed() bool {
+	return (s.capacity > 0 && int64(s.queue.Len()) > s.capacity) ||
+		(s.maxBytes > 0 && s.bytes > s.maxBytes)
+}
+
+// removeElement removes element from queue, map and reverse index.
+func (s *shard) removeElement(item *ListItem) {
+	cacheItemElement := item.Value.(cacheItem)
+
+	delete(s.items, cacheItemElement.key)
+	s.queue.Remove(item)
+	s.bytes -= cacheItemElement.meta.Size
+	s.unindexSource(cacheItemElement)
+}
+
+// clear re-init shard.
+func (s *shard) clear() {
+	s.queue = NewList()
+	s.items = make(map[string]*ListItem, s.capacity)
+	s.sources = make(map[string]map[string]struct{})
+	s.bytes = 0
+}
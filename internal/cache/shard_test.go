@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	internallogger "github.com/spendmail/s3_previewer/internal/logger"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShards(t *testing.T) {
+	t.Run("shards count", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			shards   int
+			capacity int64
+			maxBytes int64
+			expected int
+		}{
+			{"default", 0, 0, 0, defaultShards},
+			{"configured", 4, 0, 0, 4},
+			{"small capacity", 0, 2, 0, 1},
+			{"medium capacity", 0, 5 * minShardCapacity, 0, 5},
+			{"small size", 8, 0, 3 * minShardBytes, 3},
+		}
+
+		for _, tc := range tests {
+			shards := newShards(tc.shards, tc.capacity, tc.maxBytes)
+			require.Len(t, shards, tc.expected, tc.name)
+		}
+	})
+
+	t.Run("limits are split between shards", func(t *testing.T) {
+		shards := newShards(4, 4*minShardCapacity+1, 0)
+		require.Len(t, shards, 4)
+		require.Equal(t, int64(minShardCapacity+1), shards[0].capacity)
+		require.Zero(t, shards[0].maxBytes)
+	})
+
+	t.Run("parallel access", func(t *testing.T) {
+		config := newTestConfig(t)
+		config.Cache.Capacity = 8 * minShardCapacity
+		config.Cache.Shards = 8
+
+		logger, err := internallogger.New(config)
+		require.NoError(t, err)
+
+		c, err := New(config, logger)
+		require.NoError(t, err)
+		defer c.Close()
+
+		require.Len(t, c.shards, 8)
+
+		wg := &sync.WaitGroup{}
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < 200; i++ {
+					key := strconv.Itoa(g*1000 + i)
+					require.NoError(t, c.Set(key, &Item{Value: []byte(key)}))
+
+					val, err := c.Get(key)
+					if err == nil {
+						require.Equal(t, []byte(key), val.Value)
+					}
+				}
+			}(g)
+		}
+		wg.Wait()
+
+		total := 0
+		for _, s := range c.shards {
+			require.LessOrEqual(t, int64(s.queue.Len()), s.capacity)
+			total += s.queue.Len()
+		}
+		require.Len(t, dataFiles(t, config.Cache.Path), total)
+	})
+}
@@ -0,0 +1,105 @@
+package cache
+
+import (
+	"sync/atomic"
+)
+
+// Stats describes cache effectiveness. Stats of the tier a cache is put in front of are nested as Next.
+// Shared caches don't track their size, so their Entries and Bytes are zero.
+type Stats struct {
+	Name       string `json:"name"`
+	Hits       int64  `json:"hits"`
+	Misses     int64  `json:"misses"`
+	Sets       int64  `json:"sets"`
+	Evictions  int64  `json:"evictions"`
+	ReadErrors int64  `json:"read_errors"`
+	Entries    int64  `json:"entries"`
+	Bytes      int64  `json:"bytes"`
+	Next       *Stats `json:"next,omitempty"`
+	// Restore is progress of restoring the cache from filesystem, it's set for disk caches only.
+	Restore *RestoreStatus `json:"restore,omitempty"`
+}
+
+// counters are cache statistics updated atomically.
+// They have to be the first field of the cache struct, so that they are 64-bit aligned on 32-bit platforms.
+type counters struct {
+	hits       int64
+	misses     int64
+	sets       int64
+	evictions  int64
+	readErrors int64
+}
+
+// increment adds one to the counter.
+func increment(counter *int64) {
+	atomic.AddInt64(counter, 1)
+}
+
+// stats returns current counters values.
+func (c *counters) stats(name string) Stats {
+	return Stats{
+		Name:       name,
+		Hits:       atomic.LoadInt64(&c.hits),
+		Misses:     atomic.LoadInt64(&c.misses),
+		Sets:       atomic.LoadInt64(&c.sets),
+		Evictions:  atomic.LoadInt64(&c.evictions),
+		ReadErrors: atomic.LoadInt64(&c.readErrors),
+	}
+}
+
+// Stats returns cache statistics together with the number of items and their size.
+func (l *LruCache) Stats() Stats {
+	stats := l.counters.stats("disk")
+
+	restore := l.RestoreStatus()
+	stats.Restore = &restore
+
+	for _, s := range l.shards {
+		s.mutex.Lock()
+		stats.Entries += int64(s.queue.Len())
+		stats.Bytes += s.bytes
+		s.mutex.Unlock()
+	}
+
+	return stats
+}
+
+// Stats returns memory tier statistics, with the next tier ones nested.
+func (m *MemoryCache) Stats() Stats {
+	stats := m.counters.stats("memory")
+
+	m.mutex.Lock()
+	stats.Entries = int64(m.queue.Len())
+	stats.Bytes = m.bytes
+	m.mutex.Unlock()
+
+	next := m.next.Stats()
+	stats.Next = &next
+
+	return stats
+}
+
+// Stats returns redis cache statistics of this replica.
+func (r *RedisCache) Stats() Stats {
+	return r.counters.stats("redis")
+}
+
+// Stats returns s3 cache statistics of this replica.
+func (c *S3Cache) Stats() Stats {
+	return c.counters.stats("s3")
+}
+
+// Stats returns the front tier statistics, with the back tier ones nested.
+func (t *TieredCache) Stats() Stats {
+	stats := t.front.Stats()
+	back := t.back.Stats()
+
+	// Front tier could have its own next tier, so the back one is appended to the end of the chain
+	last := &stats
+	for last.Next != nil {
+		last = last.Next
+	}
+	last.Next = &back
+
+	return stats
+}
@@ -0,0 +1,94 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	internallogger "github.com/spendmail/s3_previewer/internal/logger"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats(t *testing.T) {
+	t.Run("disk cache", func(t *testing.T) {
+		config := newTestConfig(t)
+		config.Cache.Capacity = 2
+
+		logger, err := internallogger.New(config)
+		require.NoError(t, err)
+
+		c, err := New(config, logger)
+		require.NoError(t, err)
+		defer c.Close()
+		<-c.Restored()
+
+		require.NoError(t, c.Set("aaa", &Item{Value: []byte("aaa")}))
+		require.NoError(t, c.Set("bbb", &Item{Value: []byte("bbb")}))
+		require.NoError(t, c.Set("ccc", &Item{Value: []byte("ccc")}))
+		require.NoError(t, c.Set("ddd", &Item{Value: []byte("ddd"), ExpiresAt: time.Now().Add(-time.Second)}))
+
+		_, err = c.Get("aaa")
+		require.Error(t, err)
+		_, err = c.Get("ddd")
+		require.Error(t, err)
+		_, err = c.Get("ccc")
+		require.NoError(t, err)
+
+		require.NoError(t, os.Remove(filepath.Join(config.Cache.Path, filePath("ccc"))))
+		_, err = c.Get("ccc")
+		require.Error(t, err)
+
+		require.Equal(t, Stats{
+			Name:       "disk",
+			Hits:       1,
+			Misses:     3,
+			Sets:       4,
+			Evictions:  3,
+			ReadErrors: 1,
+			Entries:    0,
+			Bytes:      0,
+			Restore:    &RestoreStatus{Done: true},
+		}, c.Stats())
+	})
+
+	t.Run("tiers", func(t *testing.T) {
+		config := newTestConfig(t)
+		config.Cache.MemoryMaxBytes = 3
+		config.Cache.S3.Prefix = "previewer/"
+
+		logger, err := internallogger.New(config)
+		require.NoError(t, err)
+
+		disk, err := New(config, logger)
+		require.NoError(t, err)
+		defer disk.Close()
+
+		back, err := NewS3(config, logger, newFakeS3Client())
+		require.NoError(t, err)
+
+		m := NewMemory(config, logger, NewTiered(logger, disk, back))
+
+		require.NoError(t, m.Set("aaa", &Item{Value: []byte("aaa")}))
+		_, err = m.Get("aaa")
+		require.NoError(t, err)
+		_, err = m.Get("bbb")
+		require.Error(t, err)
+
+		stats := m.Stats()
+		require.Equal(t, "memory", stats.Name)
+		require.Equal(t, int64(1), stats.Hits)
+		require.Equal(t, int64(1), stats.Misses)
+		require.Equal(t, int64(1), stats.Entries)
+		require.Equal(t, int64(3), stats.Bytes)
+
+		require.NotNil(t, stats.Next)
+		require.Equal(t, "disk", stats.Next.Name)
+		require.Equal(t, int64(1), stats.Next.Misses)
+		require.NotNil(t, stats.Next.Restore)
+
+		require.NotNil(t, stats.Next.Next)
+		require.Equal(t, "s3", stats.Next.Next.Name)
+		require.Equal(t, int64(1), stats.Next.Next.Misses)
+	})
+}
@@ -0,0 +1,292 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+)
+
+const (
+	// Writes to the back tier are done by a fixed number of workers. When their queue is full,
+	// items are written synchronously, slowing writers down instead of piling goroutines up.
+	tieredWriteWorkers   = 4
+	tieredWriteQueueSize = 1024
+)
+
+// TieredCache is a write-through composition of a local cache in front of a shared one.
+// Items missing in the front tier are read from the back tier and promoted,
+// and items are written to the back tier in background, so that it doesn't slow responses down.
+type TieredCache struct {
+	front  Tier
+	back   Tier
+	logger Logger
+	writes chan tieredWrite
+	wg     sync.WaitGroup
+	// Writes aren't queued once the queue is closed.
+	closeMutex sync.RWMutex
+	closed     bool
+	// Removals wait for writes in progress, and writes set before removals covering them are skipped,
+	// so that removed items don't reappear in the back tier.
+	writeMutex sync.RWMutex
+	mutex      sync.Mutex
+	sequence   uint64
+	// pending keeps sequence numbers of writes which haven't been checked against removals yet.
+	pending  map[uint64]struct{}
+	removals []tieredRemoval
+}
+
+type tieredWrite struct {
+	key      string
+	item     Item
+	sequence uint64
+}
+
+// tieredRemoval is a removal of items which writes set before it are skipped.
+type tieredRemoval struct {
+	sequence uint64
+	covers   func(write tieredWrite) bool
+}
+
+// NewTiered is a tiered cache constructor: returns TieredCache instance pointer.
+func NewTiered(logger Logger, front, back Tier) *TieredCache {
+	return newTiered(logger, front, back, tieredWriteWorkers, tieredWriteQueueSize)
+}
+
+// newTiered creates tiered cache and starts workers writing to the back tier.
+func newTiered(logger Logger, front, back Tier, workers, queueSize int) *TieredCache {
+	t := &TieredCache{
+		front:   front,
+		back:    back,
+		logger:  logger,
+		writes:  make(chan tieredWrite, queueSize),
+		pending: make(map[uint64]struct{}),
+	}
+
+	t.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer t.wg.Done()
+
+			for write := range t.writes {
+				t.write(write)
+			}
+		}()
+	}
+
+	return t
+}
+
+// Get returns item from the front tier, or promotes it from the back one.
+func (t *TieredCache) Get(key string) (*Item, error) {
+	return t.GetContext(context.Background(), key)
+}
+
+// GetContext is Get passing ctx to the tiers, so that their operations are traced within the trace of ctx.
+func (t *TieredCache) GetContext(ctx context.Context, key string) (*Item, error) {
+	item, err := getTier(ctx, t.front, key)
+	if err == nil {
+		return item, nil
+	}
+
+	if !errors.Is(err, ErrItemNotExists) && !errors.Is(err, ErrItemExpired) {
+		t.logger.Warn(err)
+	}
+
+	item, err = getTier(ctx, t.back, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := setTier(ctx, t.front, key, item); err != nil {
+		t.logger.Warn(err)
+	}
+
+	return item, nil
+}
+
+// Set sets item into the front tier, and into the back one in background.
+// Metadata updates are kept by the front tier only, as the back one can't update metadata in place.
+func (t *TieredCache) Set(key string, item *Item) error {
+	return t.SetContext(context.Background(), key, item)
+}
+
+// SetContext is Set passing ctx to the front tier, so that its operations are traced within the trace of ctx.
+// Writes to the back tier outlive the caller, so they aren't traced.
+func (t *TieredCache) SetContext(ctx context.Context, key string, item *Item) error {
+	if err := setTier(ctx, t.front, key, item); err != nil {
+		return err
+	}
+
+	if item.Value == nil {
+		return nil
+	}
+
+	t.mutex.Lock()
+	t.sequence++
+	write := tieredWrite{key: key, item: *item, sequence: t.sequence}
+	t.pending[write.sequence] = struct{}{}
+	t.mutex.Unlock()
+
+	if !t.enqueue(write) {
+		t.write(write)
+	}
+
+	return nil
+}
+
+// enqueue passes write to the workers, unless the queue is full or closed.
+func (t *TieredCache) enqueue(write tieredWrite) bool {
+	t.closeMutex.RLock()
+	defer t.closeMutex.RUnlock()
+
+	if t.closed {
+		return false
+	}
+
+	select {
+	case t.writes <- write:
+		return true
+	default:
+		return false
+	}
+}
+
+// write sets item into the back tier, unless it's been removed since it was set into the front one.
+func (t *TieredCache) write(write tieredWrite) {
+	t.writeMutex.RLock()
+	defer t.writeMutex.RUnlock()
+
+	if t.isRemoved(write) {
+		return
+	}
+
+	if err := t.back.Set(write.key, &write.item); err != nil {
+		t.logger.Error(err)
+	}
+}
+
+// isRemoved checks whether the item of write has been removed since it was set.
+func (t *TieredCache) isRemoved(write tieredWrite) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	delete(t.pending, write.sequence)
+
+	removed := false
+	for _, removal := range t.removals {
+		if removal.sequence > write.sequence && removal.covers(write) {
+			removed = true
+			break
+		}
+	}
+
+	// Removals are needed only for pending writes set before them.
+	if len(t.pending) == 0 {
+		t.removals = nil
+	}
+
+	return removed
+}
+
+// Remove removes item from both tiers.
+func (t *TieredCache) Remove(key string) {
+	t.removal(func(write tieredWrite) bool {
+		return write.key == key
+	}, func() int {
+		t.front.Remove(key)
+		t.back.Remove(key)
+
+		return 0
+	})
+}
+
+// PurgeObject removes all cached variants of the source object from both tiers and returns their count.
+// Tiers mostly hold the same variants, so the larger count is returned.
+func (t *TieredCache) PurgeObject(bucket, key string) int {
+	return t.removal(func(write tieredWrite) bool {
+		return write.item.Bucket == bucket && write.item.Key == key
+	}, func() int {
+		return maxInt(t.front.PurgeObject(bucket, key), t.back.PurgeObject(bucket, key))
+	})
+}
+
+// PurgePrefix removes all cached variants of source objects which keys start with the prefix
+// from both tiers and returns their count.
+func (t *TieredCache) PurgePrefix(bucket, prefix string) int {
+	return t.removal(func(write tieredWrite) bool {
+		return write.item.Bucket == bucket && strings.HasPrefix(write.item.Key, prefix)
+	}, func() int {
+		return maxInt(t.front.PurgePrefix(bucket, prefix), t.back.PurgePrefix(bucket, prefix))
+	})
+}
+
+// Clear re-init both tiers.
+func (t *TieredCache) Clear() {
+	t.removal(func(write tieredWrite) bool {
+		return true
+	}, func() int {
+		t.front.Clear()
+		t.back.Clear()
+
+		return 0
+	})
+}
+
+// removal skips writes covered by the removal, waits for writes in progress and runs remove func.
+// Tiers are removed from without the lock, so that writes of other items aren't blocked by slow removals.
+func (t *TieredCache) removal(covers func(write tieredWrite) bool, remove func() int) int {
+	t.writeMutex.Lock()
+	t.mutex.Lock()
+	t.sequence++
+	t.removals = append(t.removals, tieredRemoval{sequence: t.sequence, covers: covers})
+	t.pruneRemovals()
+	t.mutex.Unlock()
+	t.writeMutex.Unlock()
+
+	return remove()
+}
+
+// pruneRemovals forgets removals which there are no pending writes set before. It's called under the lock.
+func (t *TieredCache) pruneRemovals() {
+	oldest := t.sequence
+	for sequence := range t.pending {
+		if sequence < oldest {
+			oldest = sequence
+		}
+	}
+
+	removals := t.removals[:0]
+	for _, removal := range t.removals {
+		if removal.sequence > oldest {
+			removals = append(removals, removal)
+		}
+	}
+	t.removals = removals
+}
+
+// Close finishes queued writes to the back tier, further writes are done synchronously.
+// Tiers themselves are left open.
+func (t *TieredCache) Close() {
+	t.closeMutex.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.writes)
+	}
+	t.closeMutex.Unlock()
+
+	t.wg.Wait()
+
+	// Writes are left in the queue only if there are no workers.
+	for write := range t.writes {
+		t.write(write)
+	}
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,173 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	internallogger "github.com/spendmail/s3_previewer/internal/logger"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingTier is a tier which purges by prefix only once released.
+type blockingTier struct {
+	Tier
+	purging chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingTier) PurgePrefix(bucket, prefix string) int {
+	close(b.purging)
+	<-b.release
+
+	return b.Tier.PurgePrefix(bucket, prefix)
+}
+
+func TestTieredCache(t *testing.T) {
+	config := newTestConfig(t)
+	config.Cache.S3.Bucket = "cache"
+	config.Cache.S3.Prefix = "previewer/"
+
+	logger, err := internallogger.New(config)
+	require.NoError(t, err)
+
+	front, err := New(config, logger)
+	require.NoError(t, err)
+	defer front.Close()
+
+	back, err := NewS3(config, logger, newFakeS3Client())
+	require.NoError(t, err)
+	c := NewTiered(logger, front, back)
+
+	require.NoError(t, c.Set("aaa", &Item{Value: []byte("value"), Bucket: "images", Key: "cat.jpg"}))
+	c.Close()
+
+	// Item is written through to the back tier
+	val, err := back.Get("aaa")
+	require.NoError(t, err)
+	require.Equal(t, []byte("value"), val.Value)
+
+	// Item missing in the front tier is promoted
+	front.Remove("aaa")
+	val, err = c.Get("aaa")
+	require.NoError(t, err)
+	require.Equal(t, []byte("value"), val.Value)
+
+	val, err = front.Get("aaa")
+	require.NoError(t, err)
+	require.Equal(t, []byte("value"), val.Value)
+
+	require.Equal(t, 1, c.PurgeObject("images", "cat.jpg"))
+	_, err = c.Get("aaa")
+	require.ErrorIs(t, err, ErrItemNotExists)
+}
+
+func TestTieredCacheWrites(t *testing.T) {
+	config := newTestConfig(t)
+	config.Cache.S3.Bucket = "cache"
+	config.Cache.S3.Prefix = "previewer/"
+
+	logger, err := internallogger.New(config)
+	require.NoError(t, err)
+
+	front, err := New(config, logger)
+	require.NoError(t, err)
+	defer front.Close()
+
+	back, err := NewS3(config, logger, newFakeS3Client())
+	require.NoError(t, err)
+
+	// No workers take writes from the queue of a single item.
+	c := newTiered(logger, front, back, 0, 1)
+
+	require.NoError(t, c.Set("aaa", &Item{Value: []byte("aaa")}))
+	require.Len(t, c.writes, 1)
+
+	// Metadata updates aren't written to the back tier.
+	require.NoError(t, c.Set("aaa", &Item{SourceETag: `"v2"`}))
+	require.Len(t, c.writes, 1)
+
+	// Item is written synchronously, as the queue is full.
+	require.NoError(t, c.Set("bbb", &Item{Value: []byte("bbb")}))
+	val, err := back.Get("bbb")
+	require.NoError(t, err)
+	require.Equal(t, []byte("bbb"), val.Value)
+
+	_, err = back.Get("aaa")
+	require.ErrorIs(t, err, ErrItemNotExists)
+
+	// Queued writes are finished on close.
+	c.Close()
+	val, err = back.Get("aaa")
+	require.NoError(t, err)
+	require.Equal(t, []byte("aaa"), val.Value)
+
+	// Writes after close are synchronous.
+	require.NoError(t, c.Set("ccc", &Item{Value: []byte("ccc")}))
+	_, err = back.Get("ccc")
+	require.NoError(t, err)
+}
+
+func TestTieredCacheRemovals(t *testing.T) {
+	config := newTestConfig(t)
+	config.Cache.S3.Bucket = "cache"
+	config.Cache.S3.Prefix = "previewer/"
+
+	logger, err := internallogger.New(config)
+	require.NoError(t, err)
+
+	front, err := New(config, logger)
+	require.NoError(t, err)
+	defer front.Close()
+
+	s3Cache, err := NewS3(config, logger, newFakeS3Client())
+	require.NoError(t, err)
+	back := &blockingTier{Tier: s3Cache, purging: make(chan struct{}), release: make(chan struct{})}
+
+	// No workers take writes from the queue.
+	c := newTiered(logger, front, back, 0, 3)
+
+	require.NoError(t, c.Set("aaa", &Item{Value: []byte("aaa"), Bucket: "images", Key: "a/1.jpg"}))
+	require.NoError(t, c.Set("bbb", &Item{Value: []byte("bbb"), Bucket: "images", Key: "b/2.jpg"}))
+	require.NoError(t, c.Set("ccc", &Item{Value: []byte("ccc"), Bucket: "images", Key: "c/3.jpg"}))
+
+	// Removals skip queued writes of the removed items only.
+	c.Remove("aaa")
+	require.Equal(t, 1, c.PurgeObject("images", "c/3.jpg"))
+	require.Equal(t, 0, c.PurgeObject("images", "d/4.jpg"))
+	c.Close()
+
+	_, err = back.Get("aaa")
+	require.ErrorIs(t, err, ErrItemNotExists)
+	_, err = back.Get("ccc")
+	require.ErrorIs(t, err, ErrItemNotExists)
+
+	val, err := back.Get("bbb")
+	require.NoError(t, err)
+	require.Equal(t, []byte("bbb"), val.Value)
+	require.Empty(t, c.removals)
+
+	// Writes aren't blocked by slow removals of the back tier.
+	purged := make(chan int)
+	go func() {
+		purged <- c.PurgePrefix("images", "b/")
+	}()
+	<-back.purging
+
+	written := make(chan error)
+	go func() {
+		written <- c.Set("ddd", &Item{Value: []byte("ddd"), Bucket: "images", Key: "d/4.jpg"})
+	}()
+
+	select {
+	case err := <-written:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("write is blocked by removal")
+	}
+
+	close(back.release)
+	require.Equal(t, 1, <-purged)
+
+	_, err = back.Get("ddd")
+	require.NoError(t, err)
+}
@@ -0,0 +1,54 @@
+package cache
+
+import (
+	"context"
+	"errors"
+
+	internalTracing "github.com/spendmail/s3_previewer/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// contextTier is a tier tracing its operations within the trace of the caller.
+type contextTier interface {
+	GetContext(ctx context.Context, key string) (*Item, error)
+	SetContext(ctx context.Context, key string, item *Item) error
+}
+
+// getTier reads item from the tier, passing ctx to tiers which trace their operations.
+func getTier(ctx context.Context, tier Tier, key string) (*Item, error) {
+	if tier, ok := tier.(contextTier); ok {
+		return tier.GetContext(ctx, key)
+	}
+
+	return tier.Get(key)
+}
+
+// setTier sets item into the tier, passing ctx to tiers which trace their operations.
+func setTier(ctx context.Context, tier Tier, key string, item *Item) error {
+	if tier, ok := tier.(contextTier); ok {
+		return tier.SetContext(ctx, key, item)
+	}
+
+	return tier.Set(key, item)
+}
+
+// startSpan starts a span of the cache operation. Operations of callers which aren't traced
+// don't start traces of their own, so a non-recording span is returned for them.
+func startSpan(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx, trace.SpanFromContext(ctx)
+	}
+
+	return internalTracing.Tracer("cache").Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// endGetSpan ends span of the read, which misses aren't errors of.
+func endGetSpan(span trace.Span, err error) {
+	span.SetAttributes(attribute.Bool("cache.hit", err == nil))
+	if errors.Is(err, ErrItemNotExists) || errors.Is(err, ErrItemExpired) {
+		err = nil
+	}
+
+	internalTracing.End(span, err)
+}
@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"context"
+	"testing"
+
+	internallogger "github.com/spendmail/s3_previewer/internal/logger"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestCacheTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	previousProvider := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(previousProvider)
+
+	config := newTestConfig(t)
+	config.Cache.MemoryMaxBytes = 10
+
+	logger, err := internallogger.New(config)
+	require.NoError(t, err)
+
+	disk, err := New(config, logger)
+	require.NoError(t, err)
+	defer disk.Close()
+
+	// Operations of callers which aren't traced don't start traces.
+	require.NoError(t, disk.Set("aaa", &Item{Value: []byte("aaaa")}))
+	_, err = disk.Get("aaa")
+	require.NoError(t, err)
+	require.Empty(t, recorder.Ended())
+
+	// Operations of tiers in front of the disk cache are traced within the trace of the caller.
+	memory := NewMemory(config, logger, disk)
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "request")
+	require.NoError(t, memory.SetContext(ctx, "bbb", &Item{Value: []byte("bbbb")}))
+	_, err = memory.GetContext(ctx, "aaa")
+	require.NoError(t, err)
+	_, err = memory.GetContext(ctx, "ccc")
+	require.ErrorIs(t, err, ErrItemNotExists)
+	parent.End()
+
+	var names []string
+	for _, span := range recorder.Ended() {
+		if span.Name() != "request" {
+			require.Equal(t, parent.SpanContext().TraceID(), span.SpanContext().TraceID())
+		}
+		names = append(names, span.Name())
+	}
+	require.Equal(t, []string{
+		"cache.lru.WriteFile", "cache.lru.Sync", "cache.lru.Set",
+		"cache.lru.ReadFile", "cache.lru.Get",
+		"cache.lru.Get",
+		"request",
+	}, names)
+
+	// Misses aren't errors.
+	for _, span := range recorder.Ended() {
+		require.Empty(t, span.Events())
+	}
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/viper"
@@ -10,28 +11,146 @@ import (
 var ErrConfigRead = errors.New("unable to read config file")
 
 type Config struct {
-	Logger LoggerConf
-	HTTP   HTTPConf
-	Cache  CacheConf
-	S3     S3Conf
+	Logger   LoggerConf
+	HTTP     HTTPConf
+	Cache    CacheConf
+	S3       S3Conf
+	Admin    AdminConf
+	Metrics  MetricsConf
+	Tracing  TracingConf
+	Redirect RedirectConf
+	Warmup   WarmupConf
+	Events   EventsConf
+	Watcher  WatcherConf
+	Health   HealthConf
+	Presets  map[string]PresetConf
 }
 
 type LoggerConf struct {
-	Level   string
-	File    string
-	Size    int
-	Backups int
-	Age     int
+	Level         string
+	File          string
+	Size          int
+	Backups       int
+	Age           int
+	Format        string
+	AccessFile    string
+	Compress      bool
+	Interval      time.Duration
+	Outputs       []string
+	SyslogNetwork string
+	SyslogAddress string
 }
 
 type HTTPConf struct {
-	Host string
-	Port string
+	Host         string
+	Port         string
+	CacheControl string
 }
 
 type CacheConf struct {
-	Capacity int64
-	Path     string
+	Backend              string
+	Capacity             int64
+	MaxBytes             int64
+	MemoryMaxBytes       int64
+	Shards               int
+	Path                 string
+	MaxAge               time.Duration
+	TTL                  time.Duration
+	NegativeTTL          time.Duration
+	StaleWhileRevalidate time.Duration
+	StaleIfError         time.Duration
+	JanitorInterval      time.Duration
+	Redis                CacheRedisConf
+	S3                   CacheS3Conf
+	Buckets              map[string]CacheBucketConf
+}
+
+// CacheS3Conf describes s3 cache tier, layered under the cache backend. It's disabled when bucket is empty.
+type CacheS3Conf struct {
+	Bucket string
+	Prefix string
+}
+
+// CacheRedisConf describes redis cache backend, shared by previewer replicas.
+type CacheRedisConf struct {
+	Address       string
+	Password      string
+	DB            int
+	Prefix        string
+	MaxEntryBytes int64
+}
+
+type AdminConf struct {
+	Token string
+}
+
+// TracingConf describes export of spans to an OTLP/HTTP collector. It's disabled when endpoint is empty.
+type TracingConf struct {
+	Endpoint    string
+	Insecure    bool
+	ServiceName string
+	SampleRatio float64
+}
+
+// MetricsConf describes Prometheus metrics endpoint. It's public when token is empty.
+type MetricsConf struct {
+	Token string
+}
+
+// RedirectConf describes delivery of large variants by redirects to presigned urls. It's disabled when bucket is empty.
+type RedirectConf struct {
+	Bucket   string
+	Prefix   string
+	MinBytes int64
+	Expires  time.Duration
+}
+
+// CacheBucketConf overrides cache settings for a single bucket.
+type CacheBucketConf struct {
+	TTL time.Duration
+}
+
+// WarmupConf describes background rendering of variants. File is a warm-up request processed at startup.
+type WarmupConf struct {
+	Concurrency int
+	File        string
+}
+
+// EventsConf describes the s3 event notifications endpoint. It's disabled when token is empty.
+// Presets are rendered for uploaded objects.
+type EventsConf struct {
+	Token   string
+	Presets []string
+}
+
+// WatcherConf describes polling of bucket prefixes for new and modified objects, which presets are rendered.
+// It's disabled when there are no prefixes.
+type WatcherConf struct {
+	Interval   time.Duration
+	Overlap    time.Duration
+	Checkpoint string
+	Presets    []string
+	Prefixes   []WatcherPrefixConf
+}
+
+// WatcherPrefixConf is a bucket prefix polled by the watcher.
+type WatcherPrefixConf struct {
+	Bucket string
+	Prefix string
+}
+
+// HealthConf describes readiness checks.
+type HealthConf struct {
+	Buckets []string
+	S3TTL   time.Duration
+	Timeout time.Duration
+}
+
+// PresetConf describes a named set of transformation parameters.
+type PresetConf struct {
+	Width  int
+	Height int
+	TTL    time.Duration
 }
 
 type S3Conf struct {
@@ -46,6 +165,21 @@ func NewConfig(path string) (*Config, error) {
 		return nil, fmt.Errorf("%w: %s", ErrConfigRead, path)
 	}
 
+	cacheBuckets := map[string]CacheBucketConf{}
+	if err := viper.UnmarshalKey("cache.buckets", &cacheBuckets); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrConfigRead, err)
+	}
+
+	var watcherPrefixes []WatcherPrefixConf
+	if err := viper.UnmarshalKey("watcher.prefixes", &watcherPrefixes); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrConfigRead, err)
+	}
+
+	presets := map[string]PresetConf{}
+	if err := viper.UnmarshalKey("presets", &presets); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrConfigRead, err)
+	}
+
 	return &Config{
 		LoggerConf{
 			viper.GetString("logger.level"),
@@ -53,19 +187,88 @@ func NewConfig(path string) (*Config, error) {
 			viper.GetInt("logger.size"),
 			viper.GetInt("logger.backups"),
 			viper.GetInt("logger.age"),
+			viper.GetString("logger.format"),
+			viper.GetString("logger.access_file"),
+			viper.GetBool("logger.compress"),
+			viper.GetDuration("logger.interval"),
+			viper.GetStringSlice("logger.outputs"),
+			viper.GetString("logger.syslog_network"),
+			viper.GetString("logger.syslog_address"),
 		},
 		HTTPConf{
 			viper.GetString("http.host"),
 			viper.GetString("http.port"),
+			viper.GetString("http.cache_control"),
 		},
 		CacheConf{
+			viper.GetString("cache.backend"),
 			viper.GetInt64("cache.capacity"),
+			viper.GetInt64("cache.max_bytes"),
+			viper.GetInt64("cache.memory_max_bytes"),
+			viper.GetInt("cache.shards"),
 			viper.GetString("cache.path"),
+			viper.GetDuration("cache.max_age"),
+			viper.GetDuration("cache.ttl"),
+			viper.GetDuration("cache.negative_ttl"),
+			viper.GetDuration("cache.stale_while_revalidate"),
+			viper.GetDuration("cache.stale_if_error"),
+			viper.GetDuration("cache.janitor_interval"),
+			CacheRedisConf{
+				viper.GetString("cache.redis.address"),
+				viper.GetString("cache.redis.password"),
+				viper.GetInt("cache.redis.db"),
+				viper.GetString("cache.redis.prefix"),
+				viper.GetInt64("cache.redis.max_entry_bytes"),
+			},
+			CacheS3Conf{
+				viper.GetString("cache.s3.bucket"),
+				viper.GetString("cache.s3.prefix"),
+			},
+			cacheBuckets,
 		},
 		S3Conf{
 			viper.GetString("s3.access_key_id"),
 			viper.GetString("s3.secret_access_key"),
 		},
+		AdminConf{
+			viper.GetString("admin.token"),
+		},
+		MetricsConf{
+			viper.GetString("metrics.token"),
+		},
+		TracingConf{
+			viper.GetString("tracing.endpoint"),
+			viper.GetBool("tracing.insecure"),
+			viper.GetString("tracing.service_name"),
+			viper.GetFloat64("tracing.sample_ratio"),
+		},
+		RedirectConf{
+			viper.GetString("redirect.bucket"),
+			viper.GetString("redirect.prefix"),
+			viper.GetInt64("redirect.min_bytes"),
+			viper.GetDuration("redirect.expires"),
+		},
+		WarmupConf{
+			viper.GetInt("warmup.concurrency"),
+			viper.GetString("warmup.file"),
+		},
+		EventsConf{
+			viper.GetString("events.token"),
+			viper.GetStringSlice("events.presets"),
+		},
+		WatcherConf{
+			viper.GetDuration("watcher.interval"),
+			viper.GetDuration("watcher.overlap"),
+			viper.GetString("watcher.checkpoint"),
+			viper.GetStringSlice("watcher.presets"),
+			watcherPrefixes,
+		},
+		HealthConf{
+			viper.GetStringSlice("health.buckets"),
+			viper.GetDuration("health.s3_ttl"),
+			viper.GetDuration("health.timeout"),
+		},
+		presets,
 	}, nil
 }
 
@@ -77,6 +280,53 @@ func (c *Config) GetLoggerFile() string {
 	return c.Logger.File
 }
 
+// GetLoggerFormat returns either "json" or "text".
+func (c *Config) GetLoggerFormat() string {
+	return c.Logger.Format
+}
+
+// GetLoggerAccessFile returns path of the access log, empty when access log is disabled.
+func (c *Config) GetLoggerAccessFile() string {
+	return c.Logger.AccessFile
+}
+
+// GetLoggerSize returns size in megabytes at which log files are rotated.
+func (c *Config) GetLoggerSize() int {
+	return c.Logger.Size
+}
+
+// GetLoggerBackups returns number of rotated log files to retain, zero means all.
+func (c *Config) GetLoggerBackups() int {
+	return c.Logger.Backups
+}
+
+// GetLoggerAge returns number of days to retain rotated log files, zero means forever.
+func (c *Config) GetLoggerAge() int {
+	return c.Logger.Age
+}
+
+func (c *Config) GetLoggerCompress() bool {
+	return c.Logger.Compress
+}
+
+// GetLoggerInterval returns how often log files are rotated regardless of their size, zero means never.
+func (c *Config) GetLoggerInterval() time.Duration {
+	return c.Logger.Interval
+}
+
+// GetLoggerOutputs returns sinks of the application log: "file", "stdout", "stderr" or "syslog".
+func (c *Config) GetLoggerOutputs() []string {
+	return c.Logger.Outputs
+}
+
+func (c *Config) GetLoggerSyslogNetwork() string {
+	return c.Logger.SyslogNetwork
+}
+
+func (c *Config) GetLoggerSyslogAddress() string {
+	return c.Logger.SyslogAddress
+}
+
 func (c *Config) GetHTTPHost() string {
 	return c.HTTP.Host
 }
@@ -85,14 +335,189 @@ func (c *Config) GetHTTPPort() string {
 	return c.HTTP.Port
 }
 
+func (c *Config) GetHTTPCacheControl() string {
+	return c.HTTP.CacheControl
+}
+
+func (c *Config) GetCacheBackend() string {
+	return c.Cache.Backend
+}
+
 func (c *Config) GetCacheCapacity() int64 {
 	return c.Cache.Capacity
 }
 
+func (c *Config) GetCacheMaxBytes() int64 {
+	return c.Cache.MaxBytes
+}
+
+func (c *Config) GetCacheMemoryMaxBytes() int64 {
+	return c.Cache.MemoryMaxBytes
+}
+
+func (c *Config) GetCacheShards() int {
+	return c.Cache.Shards
+}
+
 func (c *Config) GetCachePath() string {
 	return c.Cache.Path
 }
 
+func (c *Config) GetCacheMaxAge() time.Duration {
+	return c.Cache.MaxAge
+}
+
+func (c *Config) GetCacheTTL() time.Duration {
+	return c.Cache.TTL
+}
+
+func (c *Config) GetCacheJanitorInterval() time.Duration {
+	return c.Cache.JanitorInterval
+}
+
+func (c *Config) GetCacheRedisAddress() string {
+	return c.Cache.Redis.Address
+}
+
+func (c *Config) GetCacheRedisPassword() string {
+	return c.Cache.Redis.Password
+}
+
+func (c *Config) GetCacheRedisDB() int {
+	return c.Cache.Redis.DB
+}
+
+func (c *Config) GetCacheRedisPrefix() string {
+	return c.Cache.Redis.Prefix
+}
+
+func (c *Config) GetCacheRedisMaxEntryBytes() int64 {
+	return c.Cache.Redis.MaxEntryBytes
+}
+
+func (c *Config) GetCacheS3Bucket() string {
+	return c.Cache.S3.Bucket
+}
+
+func (c *Config) GetCacheS3Prefix() string {
+	return c.Cache.S3.Prefix
+}
+
+// GetCacheNegativeTTL returns lifetime of cached failures, zero means that failures are not cached.
+func (c *Config) GetCacheNegativeTTL() time.Duration {
+	return c.Cache.NegativeTTL
+}
+
+// GetCacheStaleWhileRevalidate returns how long after becoming stale a variant is served while it's refreshed in background.
+func (c *Config) GetCacheStaleWhileRevalidate() time.Duration {
+	return c.Cache.StaleWhileRevalidate
+}
+
+// GetCacheStaleIfError returns how long after its lifetime a variant is kept to be served if refreshing fails.
+func (c *Config) GetCacheStaleIfError() time.Duration {
+	return c.Cache.StaleIfError
+}
+
+// GetCacheBucketTTL returns TTL override for the bucket, or zero if there is none.
+func (c *Config) GetCacheBucketTTL(bucket string) time.Duration {
+	return c.Cache.Buckets[bucket].TTL
+}
+
+func (c *Config) GetAdminToken() string {
+	return c.Admin.Token
+}
+
+func (c *Config) GetMetricsToken() string {
+	return c.Metrics.Token
+}
+
+func (c *Config) GetTracingEndpoint() string {
+	return c.Tracing.Endpoint
+}
+
+func (c *Config) GetTracingInsecure() bool {
+	return c.Tracing.Insecure
+}
+
+func (c *Config) GetTracingServiceName() string {
+	return c.Tracing.ServiceName
+}
+
+func (c *Config) GetTracingSampleRatio() float64 {
+	return c.Tracing.SampleRatio
+}
+
+func (c *Config) GetRedirectBucket() string {
+	return c.Redirect.Bucket
+}
+
+func (c *Config) GetRedirectPrefix() string {
+	return c.Redirect.Prefix
+}
+
+func (c *Config) GetRedirectMinBytes() int64 {
+	return c.Redirect.MinBytes
+}
+
+func (c *Config) GetRedirectExpires() time.Duration {
+	return c.Redirect.Expires
+}
+
+func (c *Config) GetWarmupConcurrency() int {
+	return c.Warmup.Concurrency
+}
+
+func (c *Config) GetWarmupFile() string {
+	return c.Warmup.File
+}
+
+func (c *Config) GetEventsToken() string {
+	return c.Events.Token
+}
+
+func (c *Config) GetEventsPresets() []string {
+	return c.Events.Presets
+}
+
+func (c *Config) GetWatcherInterval() time.Duration {
+	return c.Watcher.Interval
+}
+
+func (c *Config) GetWatcherOverlap() time.Duration {
+	return c.Watcher.Overlap
+}
+
+func (c *Config) GetWatcherCheckpoint() string {
+	return c.Watcher.Checkpoint
+}
+
+func (c *Config) GetWatcherPresets() []string {
+	return c.Watcher.Presets
+}
+
+func (c *Config) GetWatcherPrefixes() []WatcherPrefixConf {
+	return c.Watcher.Prefixes
+}
+
+// GetHealthBuckets returns buckets which must be reachable for the service to be ready,
+// besides the ones previewer writes to.
+func (c *Config) GetHealthBuckets() []string {
+	return c.Health.Buckets
+}
+
+// GetHealthS3TTL returns how long results of s3 reachability checks are reused.
+func (c *Config) GetHealthS3TTL() time.Duration {
+	return c.Health.S3TTL
+}
+
+func (c *Config) GetHealthTimeout() time.Duration {
+	return c.Health.Timeout
+}
+
+func (c *Config) GetPresets() map[string]PresetConf {
+	return c.Presets
+}
+
 func (c *Config) GetAccessKeyId() string {
 	return c.S3.AccessKeyId
 }
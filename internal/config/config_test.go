@@ -2,6 +2,7 @@ package config
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -11,4 +12,14 @@ func TestConfig(t *testing.T) {
 		_, err := NewConfig("/very/wrong/path.conf")
 		require.ErrorIs(t, err, ErrConfigRead, "Error must be: %q, actual: %q", ErrConfigRead, err)
 	})
+
+	t.Run("cache lifetime overrides", func(t *testing.T) {
+		config, err := NewConfig("../../configs/previewer.example.toml")
+		require.NoError(t, err)
+
+		require.Equal(t, 168*time.Hour, config.GetCacheTTL())
+		require.Equal(t, time.Hour, config.GetCacheBucketTTL("avatars"))
+		require.Zero(t, config.GetCacheBucketTTL("unknown"))
+		require.Equal(t, PresetConf{Width: 150, Height: 150, TTL: 720 * time.Hour}, config.GetPresets()["thumbnail"])
+	})
 }
@@ -0,0 +1,203 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	StatusOK = "ok"
+
+	defaultS3TTL   = 30 * time.Second
+	defaultTimeout = 2 * time.Second
+)
+
+type Config interface {
+	GetHealthBuckets() []string
+	GetHealthS3TTL() time.Duration
+	GetHealthTimeout() time.Duration
+	GetRedirectBucket() string
+	GetCacheS3Bucket() string
+}
+
+type Logger interface {
+	Debug(args ...interface{})
+	Info(args ...interface{})
+	Warn(args ...interface{})
+	Error(args ...interface{})
+}
+
+type S3Client interface {
+	HeadBucket(ctx context.Context, bucket string) error
+}
+
+// Check returns an error while the checked dependency isn't ready.
+type Check func(ctx context.Context) error
+
+// Version describes the build of previewer.
+type Version struct {
+	Release   string
+	BuildDate string
+	GitHash   string
+}
+
+// Report is a result of readiness checks: either "ok" or an error of every check by its name.
+type Report struct {
+	Ready  bool              `json:"ready"`
+	Checks map[string]string `json:"checks"`
+}
+
+// Health runs readiness checks of previewer dependencies.
+type Health struct {
+	logger  Logger
+	version Version
+	timeout time.Duration
+	names   []string
+	checks  map[string]Check
+
+	mutex sync.Mutex
+	ready bool
+}
+
+// New is a health constructor. Reachability of configured buckets is checked from the start,
+// other checks are added by the owners of checked dependencies.
+func New(config Config, logger Logger, s3Client S3Client, version Version) *Health {
+	timeout := config.GetHealthTimeout()
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	ttl := config.GetHealthS3TTL()
+	if ttl <= 0 {
+		ttl = defaultS3TTL
+	}
+
+	h := &Health{
+		logger:  logger,
+		version: version,
+		timeout: timeout,
+		checks:  make(map[string]Check),
+		ready:   true,
+	}
+
+	buckets := append([]string{config.GetRedirectBucket(), config.GetCacheS3Bucket()}, config.GetHealthBuckets()...)
+	for _, bucket := range buckets {
+		if bucket == "" {
+			continue
+		}
+
+		bucket := bucket
+		h.Add("s3:"+bucket, cached(func(ctx context.Context) error {
+			return s3Client.HeadBucket(ctx, bucket)
+		}, ttl))
+	}
+
+	return h
+}
+
+// Add registers a readiness check. Checks are expected to be added before the server is started.
+func (h *Health) Add(name string, check Check) {
+	if _, exists := h.checks[name]; !exists {
+		h.names = append(h.names, name)
+	}
+	h.checks[name] = check
+}
+
+// Ready runs all checks concurrently. Service is ready when every check passes within timeout.
+func (h *Health) Ready(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	results := make([]error, len(h.names))
+
+	var wg sync.WaitGroup
+	for i, name := range h.names {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+
+			results[i] = run(ctx, check)
+		}(i, h.checks[name])
+	}
+	wg.Wait()
+
+	report := Report{Ready: true, Checks: make(map[string]string, len(h.names))}
+	for i, name := range h.names {
+		report.Checks[name] = StatusOK
+		if results[i] != nil {
+			report.Ready = false
+			report.Checks[name] = results[i].Error()
+		}
+	}
+
+	h.logTransition(report)
+
+	return report
+}
+
+// Version returns the build of previewer.
+func (h *Health) Version() Version {
+	return h.version
+}
+
+// logTransition logs readiness only when it changes, as probes are frequent.
+func (h *Health) logTransition(report Report) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if report.Ready == h.ready {
+		return
+	}
+	h.ready = report.Ready
+
+	if report.Ready {
+		h.logger.Info("service is ready")
+		return
+	}
+
+	for _, name := range h.names {
+		if status := report.Checks[name]; status != StatusOK {
+			h.logger.Warn(fmt.Sprintf("service is not ready: %s: %s", name, status))
+		}
+	}
+}
+
+// run returns the check error, or the context error if the check doesn't finish in time.
+func run(ctx context.Context, check Check) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- check(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// cached reuses result of the check for ttl, so that probes don't reach the dependency on every request.
+func cached(check Check, ttl time.Duration) Check {
+	var (
+		mutex     sync.Mutex
+		checkedAt time.Time
+		result    error
+	)
+
+	return func(ctx context.Context) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if !checkedAt.IsZero() && time.Since(checkedAt) < ttl {
+			return result
+		}
+
+		result = check(ctx)
+		checkedAt = time.Now()
+
+		return result
+	}
+}
@@ -0,0 +1,68 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	internalConfig "github.com/spendmail/s3_previewer/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeLogger struct{}
+
+func (fakeLogger) Debug(args ...interface{}) {}
+func (fakeLogger) Info(args ...interface{})  {}
+func (fakeLogger) Warn(args ...interface{})  {}
+func (fakeLogger) Error(args ...interface{}) {}
+
+type fakeS3Client struct {
+	mutex sync.Mutex
+	heads map[string]int
+	err   error
+}
+
+func (c *fakeS3Client) HeadBucket(ctx context.Context, bucket string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.heads[bucket]++
+	return c.err
+}
+
+func TestHealth(t *testing.T) {
+	config := &internalConfig.Config{
+		Redirect: internalConfig.RedirectConf{Bucket: "variants"},
+		Health:   internalConfig.HealthConf{Buckets: []string{"images"}, Timeout: 100 * time.Millisecond},
+	}
+
+	t.Run("ready", func(t *testing.T) {
+		s3Client := &fakeS3Client{heads: map[string]int{}}
+		health := New(config, fakeLogger{}, s3Client, Version{})
+		health.Add("cache", func(ctx context.Context) error { return nil })
+
+		report := health.Ready(context.Background())
+		require.True(t, report.Ready)
+		require.Equal(t, map[string]string{"s3:variants": StatusOK, "s3:images": StatusOK, "cache": StatusOK}, report.Checks)
+
+		// Buckets are checked once within ttl.
+		health.Ready(context.Background())
+		require.Equal(t, map[string]int{"variants": 1, "images": 1}, s3Client.heads)
+	})
+
+	t.Run("not ready", func(t *testing.T) {
+		s3Client := &fakeS3Client{heads: map[string]int{}, err: errors.New("access denied")}
+		health := New(config, fakeLogger{}, s3Client, Version{})
+		health.Add("slow", func(ctx context.Context) error {
+			time.Sleep(time.Second)
+			return nil
+		})
+
+		report := health.Ready(context.Background())
+		require.False(t, report.Ready)
+		require.Equal(t, "access denied", report.Checks["s3:images"])
+		require.Equal(t, context.DeadlineExceeded.Error(), report.Checks["slow"])
+	})
+}
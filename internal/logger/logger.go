@@ -1,11 +1,17 @@
 package logger
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"log/syslog"
 	"os"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	logrusSyslog "github.com/sirupsen/logrus/hooks/syslog"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -15,29 +21,70 @@ const (
 	ERROR = "error"
 )
 
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+const (
+	OutputFile   = "file"
+	OutputStdout = "stdout"
+	OutputStderr = "stderr"
+	OutputSyslog = "syslog"
+
+	syslogTag = "previewer"
+)
+
+const (
+	RequestIDField = "request_id"
+	TraceIDField   = "trace_id"
+)
+
 type Config interface {
 	GetLoggerLevel() string
 	GetLoggerFile() string
+	GetLoggerFormat() string
+	GetLoggerAccessFile() string
+	GetLoggerSize() int
+	GetLoggerBackups() int
+	GetLoggerAge() int
+	GetLoggerCompress() bool
+	GetLoggerInterval() time.Duration
+	GetLoggerOutputs() []string
+	GetLoggerSyslogNetwork() string
+	GetLoggerSyslogAddress() string
 }
 
+// Logger writes application log entries, and access log entries if access log is enabled.
 type Logger struct {
-	Logger *logrus.Logger
+	Logger   *logrus.Logger
+	entry    *logrus.Entry
+	access   *logrus.Logger
+	rotation *rotation
 }
 
-var ErrLogFileOpen = errors.New("unable to open a log file")
+var (
+	ErrLogFileOpen  = errors.New("unable to open a log file")
+	ErrLogFileClose = errors.New("unable to close a log file")
+	ErrLogRotate    = errors.New("unable to rotate a log file")
+	ErrLogOutput    = errors.New("unknown log output")
+	ErrSyslog       = errors.New("unable to connect to syslog")
+)
+
+type requestIDKey struct{}
 
-// New is a logger constructor.
+// New is a logger constructor. Application log is written to the file unless other outputs are configured,
+// log files are rotated by size and interval.
 func New(config Config) (*Logger, error) {
 	logger := logrus.New()
-	logger.Formatter = &logrus.TextFormatter{}
+	logger.Formatter = newFormatter(config.GetLoggerFormat())
 
-	file, err := os.OpenFile(config.GetLoggerFile(), os.O_APPEND|os.O_CREATE|os.O_RDWR, 0o666)
-	if err != nil {
-		return nil, fmt.Errorf("%w: %s", ErrLogFileOpen, config.GetLoggerFile())
+	rotation := newRotation()
+	if err := setOutputs(logger, config, rotation); err != nil {
+		rotation.close()
+		return nil, err
 	}
 
-	logger.SetOutput(file)
-
 	switch config.GetLoggerLevel() {
 	case DEBUG:
 		logger.SetLevel(logrus.DebugLevel)
@@ -49,35 +96,189 @@ func New(config Config) (*Logger, error) {
 		logger.SetLevel(logrus.ErrorLevel)
 	}
 
+	var access *logrus.Logger
+	if path := config.GetLoggerAccessFile(); path != "" {
+		accessFile, err := rotation.open(config, path)
+		if err != nil {
+			rotation.close()
+			return nil, err
+		}
+
+		access = logrus.New()
+		access.Formatter = newFormatter(config.GetLoggerFormat())
+		access.SetOutput(accessFile)
+	}
+
+	if interval := config.GetLoggerInterval(); interval > 0 && len(rotation.files) > 0 {
+		go rotation.run(interval, logger)
+	}
+
 	return &Logger{
-		Logger: logger,
+		Logger:   logger,
+		entry:    logrus.NewEntry(logger),
+		access:   access,
+		rotation: rotation,
 	}, nil
 }
 
+// setOutputs directs entries to configured outputs. Entries are sent to syslog by a hook,
+// so that their levels become syslog priorities.
+func setOutputs(logger *logrus.Logger, config Config, rotation *rotation) error {
+	outputs := config.GetLoggerOutputs()
+	if len(outputs) == 0 {
+		outputs = []string{OutputFile}
+	}
+
+	var writers []io.Writer
+	for _, output := range outputs {
+		switch output {
+		case OutputFile:
+			file, err := rotation.open(config, config.GetLoggerFile())
+			if err != nil {
+				return err
+			}
+			writers = append(writers, file)
+		case OutputStdout:
+			writers = append(writers, os.Stdout)
+		case OutputStderr:
+			writers = append(writers, os.Stderr)
+		case OutputSyslog:
+			hook, err := logrusSyslog.NewSyslogHook(config.GetLoggerSyslogNetwork(), config.GetLoggerSyslogAddress(), syslog.LOG_INFO|syslog.LOG_DAEMON, syslogTag)
+			if err != nil {
+				return fmt.Errorf("%w: %s", ErrSyslog, err)
+			}
+			logger.AddHook(hook)
+		default:
+			return fmt.Errorf("%w: %s", ErrLogOutput, output)
+		}
+	}
+
+	switch len(writers) {
+	case 0:
+		logger.SetOutput(io.Discard)
+	case 1:
+		logger.SetOutput(writers[0])
+	default:
+		logger.SetOutput(io.MultiWriter(writers...))
+	}
+
+	return nil
+}
+
+// newFormatter returns formatter of log entries, JSON unless text format is configured.
+func newFormatter(format string) logrus.Formatter {
+	if format == FormatText {
+		return &logrus.TextFormatter{}
+	}
+
+	return &logrus.JSONFormatter{TimestampFormat: time.RFC3339Nano}
+}
+
+// ContextWithRequestID returns a copy of ctx carrying the request ID, which is added to entries logged with the context.
+func ContextWithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestID returns the request ID carried by ctx, or an empty string.
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// Reopen closes log files, so that they are opened again by their paths. It's called when files
+// are moved away by external rotation.
+func (l *Logger) Reopen() error {
+	return l.rotation.reopen()
+}
+
+// Close stops rotation and closes log files.
+func (l *Logger) Close() {
+	l.rotation.close()
+}
+
+// WithFields returns a logger adding the fields to every entry.
+func (l *Logger) WithFields(fields map[string]interface{}) *Logger {
+	return &Logger{
+		Logger:   l.Logger,
+		entry:    l.entry.WithFields(fields),
+		access:   l.access,
+		rotation: l.rotation,
+	}
+}
+
+// WithContext returns a logger adding the request ID and the trace ID of ctx to every entry.
+func (l *Logger) WithContext(ctx context.Context) *Logger {
+	fields := map[string]interface{}{}
+
+	if requestID := RequestID(ctx); requestID != "" {
+		fields[RequestIDField] = requestID
+	}
+
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.HasTraceID() {
+		fields[TraceIDField] = spanContext.TraceID().String()
+	}
+
+	if len(fields) == 0 {
+		return l
+	}
+
+	return l.WithFields(fields)
+}
+
+// Access writes an entry of the access log.
+func (l *Logger) Access(ctx context.Context, fields map[string]interface{}) {
+	if l.access == nil {
+		return
+	}
+
+	entry := l.access.WithFields(fields)
+	if requestID := RequestID(ctx); requestID != "" {
+		entry = entry.WithField(RequestIDField, requestID)
+	}
+
+	entry.Info("access")
+}
+
 func (l *Logger) Trace(args ...interface{}) {
-	l.Logger.Trace(args...)
+	l.entry.Trace(args...)
 }
 
 func (l *Logger) Debug(args ...interface{}) {
-	l.Logger.Debug(args...)
+	l.entry.Debug(args...)
 }
 
 func (l *Logger) Info(args ...interface{}) {
-	l.Logger.Info(args...)
+	l.entry.Info(args...)
 }
 
 func (l *Logger) Warn(args ...interface{}) {
-	l.Logger.Warn(args...)
+	l.entry.Warn(args...)
 }
 
 func (l *Logger) Error(args ...interface{}) {
-	l.Logger.Error(args...)
+	l.entry.Error(args...)
 }
 
 func (l *Logger) Fatal(args ...interface{}) {
-	l.Logger.Fatal(args...)
+	l.entry.Fatal(args...)
 }
 
 func (l *Logger) Panic(args ...interface{}) {
-	l.Logger.Panic(args...)
+	l.entry.Panic(args...)
+}
+
+func (l *Logger) DebugContext(ctx context.Context, args ...interface{}) {
+	l.WithContext(ctx).Debug(args...)
+}
+
+func (l *Logger) InfoContext(ctx context.Context, args ...interface{}) {
+	l.WithContext(ctx).Info(args...)
+}
+
+func (l *Logger) WarnContext(ctx context.Context, args ...interface{}) {
+	l.WithContext(ctx).Warn(args...)
+}
+
+func (l *Logger) ErrorContext(ctx context.Context, args ...interface{}) {
+	l.WithContext(ctx).Error(args...)
 }
@@ -1,43 +1,58 @@
 package logger
 
 import (
-	"io/ioutil"
+	"context"
+	"encoding/json"
+	"errors"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
-	internalconfig "github.com/spendmail/previewer/internal/config"
+	internalconfig "github.com/spendmail/s3_previewer/internal/config"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
-func TestLogger(t *testing.T) {
-	t.Run("logger", func(t *testing.T) {
-		config, err := internalconfig.NewConfig("../../configs/previewer.toml")
-		if err != nil {
-			t.Fatal(err)
-		}
+// readEntries returns JSON entries of the log file.
+func readEntries(t *testing.T, path string) []map[string]interface{} {
+	t.Helper()
 
-		f, err := os.CreateTemp("/tmp/", "")
-		if err != nil {
-			t.Fatal(err)
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		if line == "" {
+			continue
 		}
 
-		config.Logger.Level = "debug"
-		config.Logger.File = f.Name()
+		entry := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+func TestLogger(t *testing.T) {
+	t.Run("logger", func(t *testing.T) {
+		config := &internalconfig.Config{Logger: internalconfig.LoggerConf{
+			Level: "debug",
+			File:  filepath.Join(t.TempDir(), "previewer.log"),
+		}}
 
 		logger, err := New(config)
-		if err != nil {
-			t.Fatal(err)
-		}
+		require.NoError(t, err)
+		defer logger.Close()
 
 		logger.Debug("debug_message")
 		logger.Info("info_message")
 		logger.Warn("warn_message")
 		logger.Error("error_message")
 
-		b, err := ioutil.ReadFile(f.Name())
-		if err != nil {
-			t.Fatal(err)
-		}
+		b, err := os.ReadFile(config.Logger.File)
+		require.NoError(t, err)
 
 		content := string(b)
 
@@ -46,4 +61,144 @@ func TestLogger(t *testing.T) {
 		require.Contains(t, content, "warn_message", "Log doesn't contain string error")
 		require.Contains(t, content, "error_message", "Log doesn't contain string error")
 	})
+
+	t.Run("outputs", func(t *testing.T) {
+		dir := t.TempDir()
+
+		// Log file isn't created when it isn't among the outputs.
+		config := &internalconfig.Config{Logger: internalconfig.LoggerConf{
+			File:    filepath.Join(dir, "stdout.log"),
+			Outputs: []string{OutputStdout},
+		}}
+		logger, err := New(config)
+		require.NoError(t, err)
+		logger.Info("stdout_message")
+		logger.Close()
+
+		_, err = os.Stat(config.Logger.File)
+		require.True(t, errors.Is(err, os.ErrNotExist))
+
+		config.Logger.Outputs = []string{OutputFile, OutputStderr}
+		logger, err = New(config)
+		require.NoError(t, err)
+		logger.Info("file_message")
+		logger.Close()
+
+		entries := readEntries(t, config.Logger.File)
+		require.Len(t, entries, 1)
+		require.Equal(t, "file_message", entries[0]["msg"])
+
+		config.Logger.Outputs = []string{"kafka"}
+		_, err = New(config)
+		require.Truef(t, errors.Is(err, ErrLogOutput), "actual error %q", err)
+
+		// Missing directories are created, but a file can't be created under another file.
+		config.Logger.Outputs = nil
+		config.Logger.File = filepath.Join(config.Logger.File, "previewer.log")
+		_, err = New(config)
+		require.Truef(t, errors.Is(err, ErrLogFileOpen), "actual error %q", err)
+	})
+
+	t.Run("access log", func(t *testing.T) {
+		dir := t.TempDir()
+		config := &internalconfig.Config{Logger: internalconfig.LoggerConf{
+			Level:      "info",
+			File:       filepath.Join(dir, "previewer.log"),
+			AccessFile: filepath.Join(dir, "access.log"),
+		}}
+
+		logger, err := New(config)
+		require.NoError(t, err)
+		defer logger.Close()
+
+		ctx := ContextWithRequestID(context.Background(), "abc")
+		logger.Access(ctx, map[string]interface{}{"method": "GET", "status": 200})
+		logger.InfoContext(ctx, "info_message")
+
+		entries := readEntries(t, config.Logger.AccessFile)
+		require.Len(t, entries, 1)
+		require.Equal(t, "access", entries[0]["msg"])
+		require.Equal(t, "GET", entries[0]["method"])
+		require.Equal(t, float64(200), entries[0]["status"])
+		require.Equal(t, "abc", entries[0][RequestIDField])
+
+		// Access entries aren't written to the application log.
+		entries = readEntries(t, config.Logger.File)
+		require.Len(t, entries, 1)
+		require.Equal(t, "info_message", entries[0]["msg"])
+		require.Equal(t, "abc", entries[0][RequestIDField])
+	})
+
+	t.Run("reopen", func(t *testing.T) {
+		dir := t.TempDir()
+		config := &internalconfig.Config{Logger: internalconfig.LoggerConf{
+			File:       filepath.Join(dir, "previewer.log"),
+			AccessFile: filepath.Join(dir, "access.log"),
+		}}
+
+		logger, err := New(config)
+		require.NoError(t, err)
+		defer logger.Close()
+
+		logger.Info("before_message")
+
+		// External rotation moves the file away, entries go to the moved file until it's reopened.
+		moved := filepath.Join(dir, "previewer.log.1")
+		require.NoError(t, os.Rename(config.Logger.File, moved))
+		logger.Info("moved_message")
+
+		require.NoError(t, logger.Reopen())
+		logger.Info("after_message")
+		logger.Access(context.Background(), map[string]interface{}{"status": 200})
+
+		entries := readEntries(t, moved)
+		require.Len(t, entries, 2)
+		require.Equal(t, "before_message", entries[0]["msg"])
+		require.Equal(t, "moved_message", entries[1]["msg"])
+
+		entries = readEntries(t, config.Logger.File)
+		require.Len(t, entries, 1)
+		require.Equal(t, "after_message", entries[0]["msg"])
+
+		require.Len(t, readEntries(t, config.Logger.AccessFile), 1)
+	})
+
+	t.Run("rotation", func(t *testing.T) {
+		dir := t.TempDir()
+		config := &internalconfig.Config{Logger: internalconfig.LoggerConf{
+			File:       filepath.Join(dir, "previewer.log"),
+			AccessFile: filepath.Join(dir, "access.log"),
+		}}
+
+		logger, err := New(config)
+		require.NoError(t, err)
+		defer logger.Close()
+
+		// Non-empty files are rotated, empty ones are left as they are.
+		logger.Info("rotated_message")
+		logger.rotation.rotate(logger.Logger)
+
+		backups, err := filepath.Glob(filepath.Join(dir, "previewer-*.log"))
+		require.NoError(t, err)
+		require.Len(t, backups, 1)
+		require.Equal(t, "rotated_message", readEntries(t, backups[0])[0]["msg"])
+
+		backups, err = filepath.Glob(filepath.Join(dir, "access-*.log"))
+		require.NoError(t, err)
+		require.Empty(t, backups)
+
+		// Rotation failures are logged.
+		logger.Info("kept_message")
+		logger.rotation.rotateFile = func(file *lumberjack.Logger) error {
+			return errors.New("disk is full")
+		}
+		logger.rotation.rotate(logger.Logger)
+
+		entries := readEntries(t, config.Logger.File)
+		require.Len(t, entries, 2)
+		require.Equal(t, "kept_message", entries[0]["msg"])
+		require.Equal(t, "error", entries[1]["level"])
+		require.Contains(t, entries[1]["msg"], ErrLogRotate.Error())
+		require.Contains(t, entries[1]["msg"], "disk is full")
+	})
 }
@@ -0,0 +1,96 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// rotation keeps log files, rotated by size and on schedule. Rotated files are compressed
+// and removed according to retention settings.
+type rotation struct {
+	files []*lumberjack.Logger
+	done  chan struct{}
+	once  sync.Once
+	// rotateFile rotates the file, it's replaced by tests.
+	rotateFile func(file *lumberjack.Logger) error
+}
+
+func newRotation() *rotation {
+	return &rotation{done: make(chan struct{}), rotateFile: (*lumberjack.Logger).Rotate}
+}
+
+// open returns writer of the log file, failing if the file can't be opened.
+func (r *rotation) open(config Config, path string) (*lumberjack.Logger, error) {
+	file := &lumberjack.Logger{
+		Filename:   path,
+		MaxSize:    config.GetLoggerSize(),
+		MaxBackups: config.GetLoggerBackups(),
+		MaxAge:     config.GetLoggerAge(),
+		Compress:   config.GetLoggerCompress(),
+		LocalTime:  true,
+	}
+
+	// Files are opened on the first write, so empty one checks that the file is writable.
+	if _, err := file.Write(nil); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrLogFileOpen, path)
+	}
+
+	r.files = append(r.files, file)
+
+	return file, nil
+}
+
+// run rotates files every interval, until rotation is closed.
+func (r *rotation) run(interval time.Duration, logger *logrus.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.rotate(logger)
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// rotate rotates non-empty files, logging failures.
+func (r *rotation) rotate(logger *logrus.Logger) {
+	for _, file := range r.files {
+		if info, err := os.Stat(file.Filename); err != nil || info.Size() == 0 {
+			continue
+		}
+
+		if err := r.rotateFile(file); err != nil {
+			logger.Error(fmt.Errorf("%w: %s: %s", ErrLogRotate, file.Filename, err))
+		}
+	}
+}
+
+// reopen closes files, so that they are opened by their paths on the next write.
+func (r *rotation) reopen() error {
+	for _, file := range r.files {
+		if err := file.Close(); err != nil {
+			return fmt.Errorf("%w: %s", ErrLogFileClose, err)
+		}
+	}
+
+	return nil
+}
+
+// close stops scheduled rotation and closes files.
+func (r *rotation) close() {
+	r.once.Do(func() {
+		close(r.done)
+
+		for _, file := range r.files {
+			_ = file.Close()
+		}
+	})
+}
@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	internalCache "github.com/spendmail/s3_previewer/internal/cache"
+)
+
+var (
+	cacheHitsDesc       = newCacheDesc("hits_total", "Number of cache hits by tier.")
+	cacheMissesDesc     = newCacheDesc("misses_total", "Number of cache misses by tier.")
+	cacheSetsDesc       = newCacheDesc("sets_total", "Number of items stored by tier.")
+	cacheEvictionsDesc  = newCacheDesc("evictions_total", "Number of items evicted by tier.")
+	cacheReadErrorsDesc = newCacheDesc("read_errors_total", "Number of failed reads by tier.")
+	cacheEntriesDesc    = newCacheDesc("entries", "Number of items by tier, zero for shared tiers.")
+	cacheBytesDesc      = newCacheDesc("bytes", "Size of items by tier, zero for shared tiers.")
+	cacheHitRatioDesc   = newCacheDesc("hit_ratio", "Ratio of hits to lookups by tier.")
+	// Restoration metrics are reported by tiers restored from filesystem only.
+	cacheRestoreDoneDesc        = newCacheDesc("restore_done", "Whether the tier is restored from filesystem.")
+	cacheRestoreProcessedDesc   = newCacheDesc("restore_processed_files", "Number of files processed by restoration by tier.")
+	cacheRestoreRestoredDesc    = newCacheDesc("restore_restored_files", "Number of files restored by tier.")
+	cacheRestoreQuarantinedDesc = newCacheDesc("restore_quarantined_files", "Number of files quarantined by restoration by tier.")
+)
+
+// cacheCollector converts cache statistics into metrics on every scrape.
+type cacheCollector struct {
+	stats func() internalCache.Stats
+}
+
+func newCacheCollector(stats func() internalCache.Stats) *cacheCollector {
+	return &cacheCollector{stats: stats}
+}
+
+func newCacheDesc(name, help string) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(namespace, "cache", name), help, []string{"tier"}, nil)
+}
+
+// Describe sends descriptors of cache metrics.
+func (c *cacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		cacheHitsDesc, cacheMissesDesc, cacheSetsDesc, cacheEvictionsDesc,
+		cacheReadErrorsDesc, cacheEntriesDesc, cacheBytesDesc, cacheHitRatioDesc,
+		cacheRestoreDoneDesc, cacheRestoreProcessedDesc, cacheRestoreRestoredDesc, cacheRestoreQuarantinedDesc,
+	} {
+		ch <- desc
+	}
+}
+
+// Collect sends metrics of every tier in the chain.
+func (c *cacheCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.stats()
+
+	for tier := &stats; tier != nil; tier = tier.Next {
+		ch <- prometheus.MustNewConstMetric(cacheHitsDesc, prometheus.CounterValue, float64(tier.Hits), tier.Name)
+		ch <- prometheus.MustNewConstMetric(cacheMissesDesc, prometheus.CounterValue, float64(tier.Misses), tier.Name)
+		ch <- prometheus.MustNewConstMetric(cacheSetsDesc, prometheus.CounterValue, float64(tier.Sets), tier.Name)
+		ch <- prometheus.MustNewConstMetric(cacheEvictionsDesc, prometheus.CounterValue, float64(tier.Evictions), tier.Name)
+		ch <- prometheus.MustNewConstMetric(cacheReadErrorsDesc, prometheus.CounterValue, float64(tier.ReadErrors), tier.Name)
+		ch <- prometheus.MustNewConstMetric(cacheEntriesDesc, prometheus.GaugeValue, float64(tier.Entries), tier.Name)
+		ch <- prometheus.MustNewConstMetric(cacheBytesDesc, prometheus.GaugeValue, float64(tier.Bytes), tier.Name)
+
+		ratio := 0.0
+		if lookups := tier.Hits + tier.Misses; lookups > 0 {
+			ratio = float64(tier.Hits) / float64(lookups)
+		}
+		ch <- prometheus.MustNewConstMetric(cacheHitRatioDesc, prometheus.GaugeValue, ratio, tier.Name)
+
+		if restore := tier.Restore; restore != nil {
+			done := 0.0
+			if restore.Done {
+				done = 1
+			}
+			ch <- prometheus.MustNewConstMetric(cacheRestoreDoneDesc, prometheus.GaugeValue, done, tier.Name)
+			ch <- prometheus.MustNewConstMetric(cacheRestoreProcessedDesc, prometheus.GaugeValue, float64(restore.Processed), tier.Name)
+			ch <- prometheus.MustNewConstMetric(cacheRestoreRestoredDesc, prometheus.GaugeValue, float64(restore.Restored), tier.Name)
+			ch <- prometheus.MustNewConstMetric(cacheRestoreQuarantinedDesc, prometheus.GaugeValue, float64(restore.Quarantined), tier.Name)
+		}
+	}
+}
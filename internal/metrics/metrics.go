@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	internalCache "github.com/spendmail/s3_previewer/internal/cache"
+)
+
+const namespace = "previewer"
+
+// Larger side of resized images is bucketed, so that dimensions label has few values.
+var dimensionsBuckets = []int{64, 256, 1024, 4096}
+
+var (
+	registry = prometheus.NewRegistry()
+
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of HTTP requests by route, method and status.",
+	}, []string{"route", "method", "status"})
+
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests by route, method and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	httpRequestsInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "http_requests_in_flight",
+		Help:      "Number of HTTP requests being served.",
+	})
+
+	s3DownloadDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "s3_download_duration_seconds",
+		Help:      "Latency of s3 object downloads by result.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"result"})
+
+	s3DownloadBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "s3_download_bytes_total",
+		Help:      "Number of bytes downloaded from s3.",
+	})
+
+	resizeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "resize_duration_seconds",
+		Help:      "Duration of image resizing by source format and larger side of the result.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"format", "dimensions"})
+)
+
+func init() {
+	registry.MustRegister(
+		httpRequests,
+		httpRequestDuration,
+		httpRequestsInFlight,
+		s3DownloadDuration,
+		s3DownloadBytes,
+		resizeDuration,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Handler serves metrics in Prometheus format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// RequestStarted counts in-flight request. Returned func is to be called when the request is served.
+func RequestStarted() func() {
+	httpRequestsInFlight.Inc()
+
+	return httpRequestsInFlight.Dec
+}
+
+// ObserveRequest records a served HTTP request.
+func ObserveRequest(route, method string, status int, duration time.Duration) {
+	labels := prometheus.Labels{"route": route, "method": method, "status": strconv.Itoa(status)}
+
+	httpRequests.With(labels).Inc()
+	httpRequestDuration.With(labels).Observe(duration.Seconds())
+}
+
+// ObserveS3Download records an s3 object download. Result is either "ok" or the kind of failure.
+func ObserveS3Download(result string, bytes int, duration time.Duration) {
+	s3DownloadDuration.WithLabelValues(result).Observe(duration.Seconds())
+	s3DownloadBytes.Add(float64(bytes))
+}
+
+// ObserveResize records resizing of an image.
+func ObserveResize(format string, width, height int, duration time.Duration) {
+	resizeDuration.WithLabelValues(format, dimensionsBucket(width, height)).Observe(duration.Seconds())
+}
+
+// RegisterCache exposes statistics of every cache tier.
+func RegisterCache(stats func() internalCache.Stats) {
+	registry.MustRegister(newCacheCollector(stats))
+}
+
+// dimensionsBucket returns the smallest bucket fitting larger side of the image.
+func dimensionsBucket(width, height int) string {
+	side := width
+	if height > side {
+		side = height
+	}
+
+	for _, bucket := range dimensionsBuckets {
+		if side <= bucket {
+			return strconv.Itoa(bucket)
+		}
+	}
+
+	return "+Inf"
+}
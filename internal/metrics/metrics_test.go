@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	internalCache "github.com/spendmail/s3_previewer/internal/cache"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Run("dimensions bucket", func(t *testing.T) {
+		require.Equal(t, "64", dimensionsBucket(64, 10))
+		require.Equal(t, "256", dimensionsBucket(100, 150))
+		require.Equal(t, "1024", dimensionsBucket(1024, 1024))
+		require.Equal(t, "+Inf", dimensionsBucket(100, 5000))
+	})
+
+	t.Run("cache collector", func(t *testing.T) {
+		collector := newCacheCollector(func() internalCache.Stats {
+			return internalCache.Stats{
+				Name: "memory", Hits: 3, Misses: 1, Entries: 2,
+				Next: &internalCache.Stats{
+					Name: "disk", Hits: 1,
+					Restore: &internalCache.RestoreStatus{Processed: 10, Restored: 8, Quarantined: 1},
+				},
+			}
+		})
+
+		expected := `
+# HELP previewer_cache_hit_ratio Ratio of hits to lookups by tier.
+# TYPE previewer_cache_hit_ratio gauge
+previewer_cache_hit_ratio{tier="disk"} 1
+previewer_cache_hit_ratio{tier="memory"} 0.75
+# HELP previewer_cache_hits_total Number of cache hits by tier.
+# TYPE previewer_cache_hits_total counter
+previewer_cache_hits_total{tier="disk"} 1
+previewer_cache_hits_total{tier="memory"} 3
+# HELP previewer_cache_restore_done Whether the tier is restored from filesystem.
+# TYPE previewer_cache_restore_done gauge
+previewer_cache_restore_done{tier="disk"} 0
+# HELP previewer_cache_restore_processed_files Number of files processed by restoration by tier.
+# TYPE previewer_cache_restore_processed_files gauge
+previewer_cache_restore_processed_files{tier="disk"} 10
+# HELP previewer_cache_restore_quarantined_files Number of files quarantined by restoration by tier.
+# TYPE previewer_cache_restore_quarantined_files gauge
+previewer_cache_restore_quarantined_files{tier="disk"} 1
+# HELP previewer_cache_restore_restored_files Number of files restored by tier.
+# TYPE previewer_cache_restore_restored_files gauge
+previewer_cache_restore_restored_files{tier="disk"} 8
+`
+		err := testutil.CollectAndCompare(collector, strings.NewReader(expected),
+			"previewer_cache_hit_ratio", "previewer_cache_hits_total", "previewer_cache_restore_done",
+			"previewer_cache_restore_processed_files", "previewer_cache_restore_quarantined_files",
+			"previewer_cache_restore_restored_files")
+		require.NoError(t, err)
+	})
+}
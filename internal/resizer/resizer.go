@@ -2,12 +2,20 @@ package resizer
 
 import (
 	"bytes"
+	"context"
 	"github.com/nfnt/resize"
 	"github.com/pkg/errors"
 	"image"
 	"image/jpeg"
 	"image/png"
 	"net/http"
+	"time"
+
+	internalMetrics "github.com/spendmail/s3_previewer/internal/metrics"
+	internalTracing "github.com/spendmail/s3_previewer/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Resizer struct{}
@@ -22,14 +30,38 @@ const (
 	MimeJpeg = "image/jpeg"
 )
 
-func (r *Resizer) Resize(width, height uint, imageBytes []byte) ([]byte, error) {
+func (r *Resizer) Resize(ctx context.Context, width, height uint, imageBytes []byte) ([]byte, error) {
+	start := time.Now()
+
+	ctx, span := internalTracing.Tracer("resizer").Start(ctx, "resizer.Resize", trace.WithAttributes(
+		attribute.Int("image.width", int(width)),
+		attribute.Int("image.height", int(height)),
+		attribute.Int("image.source_bytes", len(imageBytes)),
+	))
+	defer span.End()
 
-	originalImage, _, err := image.Decode(bytes.NewReader(imageBytes))
+	_, decodeSpan := internalTracing.Tracer("resizer").Start(ctx, "resizer.Decode")
+	originalImage, format, err := image.Decode(bytes.NewReader(imageBytes))
 	if err != nil {
+		decodeSpan.RecordError(err)
+		decodeSpan.SetStatus(codes.Error, err.Error())
+		decodeSpan.End()
+		span.SetStatus(codes.Error, err.Error())
 		return []byte{}, err
 	}
+	decodeSpan.End()
+	span.SetAttributes(attribute.String("image.format", format))
 
+	defer func() {
+		internalMetrics.ObserveResize(format, int(width), int(height), time.Since(start))
+	}()
+
+	_, scaleSpan := internalTracing.Tracer("resizer").Start(ctx, "resizer.Scale")
 	newImage := resize.Resize(width, height, originalImage, resize.Lanczos3)
+	scaleSpan.End()
+
+	_, encodeSpan := internalTracing.Tracer("resizer").Start(ctx, "resizer.Encode")
+	defer encodeSpan.End()
 	buf := new(bytes.Buffer)
 
 	mimeType := http.DetectContentType(imageBytes)
@@ -41,5 +73,12 @@ func (r *Resizer) Resize(width, height uint, imageBytes []byte) ([]byte, error)
 		err = errors.New("file type is not supported")
 	}
 
+	if err != nil {
+		encodeSpan.RecordError(err)
+		encodeSpan.SetStatus(codes.Error, err.Error())
+		span.SetStatus(codes.Error, err.Error())
+		return []byte{}, err
+	}
+
 	return buf.Bytes(), nil
 }
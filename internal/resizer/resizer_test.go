@@ -34,7 +34,7 @@ func TestResizer(t *testing.T) {
 		imageBytes, err := io.ReadAll(response.Body)
 		require.NoError(t, err, "should be without errors")
 
-		croppedImageBytes, err := resizer.Resize(uint(ImageWidth), uint(ImageHeight), imageBytes)
+		croppedImageBytes, err := resizer.Resize(context.Background(), uint(ImageWidth), uint(ImageHeight), imageBytes)
 		require.NoError(t, err, "should be without errors")
 
 		img, _, err := image.DecodeConfig(bytes.NewReader(croppedImageBytes))
@@ -1,13 +1,25 @@
 package s3
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	s3config "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"io"
-	"io/ioutil"
+	"github.com/aws/smithy-go"
+	internalTracing "github.com/spendmail/s3_previewer/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Config interface {
@@ -28,9 +40,36 @@ type Client struct {
 	client *s3.Client
 }
 
+// Object is a downloaded s3 object together with its metadata.
+type Object struct {
+	Body         []byte
+	ETag         string
+	LastModified time.Time
+	ContentType  string
+	Metadata     map[string]string
+}
+
+// ObjectSummary describes an object listed under a prefix.
+type ObjectSummary struct {
+	Key          string
+	ETag         string
+	LastModified time.Time
+	Size         int64
+}
+
+var (
+	ErrObjectNotFound    = errors.New("object not found")
+	ErrObjectRead        = errors.New("unable to read an object")
+	ErrObjectNotModified = errors.New("object not modified")
+	ErrObjectWrite       = errors.New("unable to write an object")
+	ErrObjectRemove      = errors.New("unable to remove an object")
+	ErrObjectList        = errors.New("unable to list objects")
+	ErrObjectPresign     = errors.New("unable to presign an object url")
+	ErrBucketUnreachable = errors.New("bucket is unreachable")
+)
+
 // New is a s3 client constructor.
 func New(config Config, logger Logger) (*Client, error) {
-
 	cfg, err := s3config.LoadDefaultConfig(
 		context.TODO(),
 		s3config.WithCredentialsProvider(
@@ -42,7 +81,6 @@ func New(config Config, logger Logger) (*Client, error) {
 			},
 		),
 	)
-
 	if err != nil {
 		return nil, err
 	}
@@ -56,24 +94,195 @@ func New(config Config, logger Logger) (*Client, error) {
 	}, nil
 }
 
-func (c *Client) Download(context context.Context, bucket, key string) ([]byte, error) {
+// Download fetches an object body and metadata.
+func (c *Client) Download(ctx context.Context, bucket, key string) (*Object, error) {
+	return c.DownloadIfChanged(ctx, bucket, key, "")
+}
+
+// DownloadIfChanged fetches an object unless its ETag still equals the given one,
+// in which case ErrObjectNotModified is returned. Empty ETag disables the check.
+func (c *Client) DownloadIfChanged(ctx context.Context, bucket, key, etag string) (object *Object, err error) {
+	ctx, span := internalTracing.Tracer("s3").Start(ctx, "s3.GetObject", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("s3.bucket", bucket),
+		attribute.String("s3.key", key),
+		attribute.Bool("s3.conditional", etag != ""),
+	))
+	defer func() {
+		switch {
+		case err == nil:
+			span.SetAttributes(attribute.Int("s3.bytes", len(object.Body)))
+		case errors.Is(err, ErrObjectNotModified):
+			span.SetAttributes(attribute.Bool("s3.not_modified", true))
+		default:
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
 
-	response, err := c.client.GetObject(context, &s3.GetObjectInput{
+	input := &s3.GetObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(key),
-	})
+	}
+
+	if etag != "" {
+		input.IfNoneMatch = aws.String(etag)
+	}
+
+	response, err := c.client.GetObject(ctx, input)
+	if err != nil {
+		return nil, wrapError(err)
+	}
 
 	defer func(Body io.ReadCloser) {
-		err := Body.Close()
+		if err := Body.Close(); err != nil {
+			c.logger.Warn(err)
+		}
+	}(response.Body)
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrObjectRead, err)
+	}
+
+	return &Object{
+		Body:         body,
+		ETag:         aws.ToString(response.ETag),
+		LastModified: aws.ToTime(response.LastModified),
+		ContentType:  aws.ToString(response.ContentType),
+		Metadata:     response.Metadata,
+	}, nil
+}
+
+// Head fetches object metadata without downloading the body.
+func (c *Client) Head(ctx context.Context, bucket, key string) (*Object, error) {
+	response, err := c.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, wrapError(err)
+	}
+
+	return &Object{
+		ETag:         aws.ToString(response.ETag),
+		LastModified: aws.ToTime(response.LastModified),
+		ContentType:  aws.ToString(response.ContentType),
+		Metadata:     response.Metadata,
+	}, nil
+}
+
+// HeadBucket checks that the bucket exists and is accessible with configured credentials.
+func (c *Client) HeadBucket(ctx context.Context, bucket string) error {
+	if _, err := c.client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucket)}); err != nil {
+		return fmt.Errorf("%w: %s: %s", ErrBucketUnreachable, bucket, err)
+	}
+
+	return nil
+}
+
+// Upload stores object body together with its content type and metadata.
+func (c *Client) Upload(ctx context.Context, bucket, key string, object *Object) error {
+	input := &s3.PutObjectInput{
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(key),
+		Body:     bytes.NewReader(object.Body),
+		Metadata: object.Metadata,
+	}
+
+	if object.ContentType != "" {
+		input.ContentType = aws.String(object.ContentType)
+	}
+
+	if _, err := c.client.PutObject(ctx, input); err != nil {
+		return fmt.Errorf("%w: %s", ErrObjectWrite, err)
+	}
+
+	return nil
+}
+
+// Remove deletes an object. Missing object isn't an error.
+func (c *Client) Remove(ctx context.Context, bucket, key string) error {
+	_, err := c.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrObjectRemove, err)
+	}
+
+	return nil
+}
+
+// List returns keys of all objects which keys start with the prefix.
+func (c *Client) List(ctx context.Context, bucket, prefix string) ([]string, error) {
+	objects, err := c.ListObjects(ctx, bucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(objects))
+	for _, object := range objects {
+		keys = append(keys, object.Key)
+	}
+
+	return keys, nil
+}
+
+// ListObjects returns summaries of objects under the prefix.
+func (c *Client) ListObjects(ctx context.Context, bucket, prefix string) ([]ObjectSummary, error) {
+	paginator := s3.NewListObjectsV2Paginator(c.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	})
+
+	var objects []ObjectSummary
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
 		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrObjectList, err)
+		}
 
+		for _, object := range page.Contents {
+			objects = append(objects, ObjectSummary{
+				Key:          aws.ToString(object.Key),
+				ETag:         aws.ToString(object.ETag),
+				LastModified: aws.ToTime(object.LastModified),
+				Size:         object.Size,
+			})
 		}
-	}(response.Body)
+	}
 
-	bytes, err := ioutil.ReadAll(response.Body)
+	return objects, nil
+}
+
+// Presign returns a GetObject url valid during the given time.
+func (c *Client) Presign(ctx context.Context, bucket, key string, expires time.Duration) (string, error) {
+	request, err := s3.NewPresignClient(c.client).PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(expires))
 	if err != nil {
-		return []byte{}, err
+		return "", fmt.Errorf("%w: %s", ErrObjectPresign, err)
+	}
+
+	return request.URL, nil
+}
+
+// wrapError converts s3 api errors into package errors.
+func wrapError(err error) error {
+	var responseErr *awshttp.ResponseError
+	if errors.As(err, &responseErr) && responseErr.HTTPStatusCode() == http.StatusNotModified {
+		return fmt.Errorf("%w: %s", ErrObjectNotModified, err)
+	}
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "NoSuchKey", "NotFound":
+			return fmt.Errorf("%w: %s", ErrObjectNotFound, err)
+		}
 	}
 
-	return bytes, err
+	return err
 }
@@ -0,0 +1,116 @@
+package http
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	internalApp "github.com/spendmail/s3_previewer/internal/app"
+)
+
+// PurgeResponse is a response of cache purging endpoints.
+type PurgeResponse struct {
+	Purged int `json:"purged"`
+}
+
+// tokenAuthMiddleware allows only requests bearing the token. Token could also be given as basic auth password,
+// as sns delivers credentials of subscription url that way.
+func tokenAuthMiddleware(token string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if _, password, ok := r.BasicAuth(); ok {
+				given = password
+			}
+			if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// purgeObjectHandler removes all cached variants of a source object.
+func (h *Handler) purgeObjectHandler(w http.ResponseWriter, r *http.Request) {
+	bucket, key := mux.Vars(r)[BucketField], mux.Vars(r)[KeyField]
+
+	purged := h.App.PurgeObject(r.Context(), bucket, key)
+	h.Logger.InfoContext(r.Context(), fmt.Sprintf("purged %d cached variants of %s/%s", purged, bucket, key))
+
+	h.sendJSON(w, http.StatusOK, PurgeResponse{Purged: purged})
+}
+
+// purgePrefixHandler removes all cached variants of source objects under a prefix.
+// Variants of the whole bucket are purged only if it's asked explicitly, by an empty prefix or all=true.
+func (h *Handler) purgePrefixHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if _, exists := query[PrefixParameter]; !exists && query.Get(AllParameter) != "true" {
+		h.Logger.InfoContext(r.Context(), ErrPrefixRequired)
+		http.Error(w, ErrPrefixRequired.Error(), http.StatusBadRequest)
+		return
+	}
+
+	bucket, prefix := mux.Vars(r)[BucketField], query.Get(PrefixParameter)
+
+	purged := h.App.PurgePrefix(r.Context(), bucket, prefix)
+	h.Logger.InfoContext(r.Context(), fmt.Sprintf("purged %d cached variants of %s/%s*", purged, bucket, prefix))
+
+	h.sendJSON(w, http.StatusOK, PurgeResponse{Purged: purged})
+}
+
+// statsHandler reports cache statistics.
+func (h *Handler) statsHandler(w http.ResponseWriter, r *http.Request) {
+	h.sendJSON(w, http.StatusOK, h.App.CacheStats())
+}
+
+// startWarmupHandler starts rendering of the requested variants in background.
+func (h *Handler) startWarmupHandler(w http.ResponseWriter, r *http.Request) {
+	var request internalApp.WarmupRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		h.Logger.InfoContext(r.Context(), fmt.Errorf("%w: %s", ErrRequestParse, err))
+		http.Error(w, ErrRequestParse.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Job outlives the request, so it doesn't inherit the request context.
+	job, err := h.App.StartWarmup(h.jobs, request)
+	if err != nil {
+		h.Logger.InfoContext(r.Context(), err)
+		status := http.StatusInternalServerError
+		if errors.Is(err, internalApp.ErrWarmupRequest) {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	h.Logger.InfoContext(r.Context(), fmt.Sprintf("warm-up job %s is started", job.ID))
+
+	h.sendJSON(w, http.StatusAccepted, job)
+}
+
+// warmupJobHandler reports progress of the warm-up job.
+func (h *Handler) warmupJobHandler(w http.ResponseWriter, r *http.Request) {
+	job, exists := h.App.GetWarmupJob(mux.Vars(r)[JobIDField])
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	h.sendJSON(w, http.StatusOK, job)
+}
+
+// sendJSON writes value as a JSON response with the status.
+func (h *Handler) sendJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		h.Logger.Error(fmt.Errorf("%w: %s", ErrResponseWrite, err))
+	}
+}
@@ -0,0 +1,251 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	internalApp "github.com/spendmail/s3_previewer/internal/app"
+	internalCache "github.com/spendmail/s3_previewer/internal/cache"
+	internalConfig "github.com/spendmail/s3_previewer/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeLogger struct{}
+
+func (fakeLogger) Debug(args ...interface{}) {}
+func (fakeLogger) Info(args ...interface{})  {}
+func (fakeLogger) Warn(args ...interface{})  {}
+func (fakeLogger) Error(args ...interface{}) {}
+
+func (fakeLogger) InfoContext(ctx context.Context, args ...interface{})      {}
+func (fakeLogger) ErrorContext(ctx context.Context, args ...interface{})     {}
+func (fakeLogger) Access(ctx context.Context, fields map[string]interface{}) {}
+
+type fakeApp struct {
+	purged []string
+	image  *internalApp.Image
+	// info is returned for variants which aren't cached, nil means the variant is cached.
+	info    *internalApp.Image
+	resizes int
+	warmup  *internalApp.WarmupRequest
+	events  []internalApp.ObjectEvent
+	// jobs is a context background jobs are started with.
+	jobs   context.Context
+	waited bool
+}
+
+func (a *fakeApp) ResizeImageByURL(ctx context.Context, width, height int, bucket string, key string, headers map[string][]string) (*internalApp.Image, error) {
+	a.resizes++
+	if a.image != nil {
+		return a.image, nil
+	}
+
+	return &internalApp.Image{Bytes: []byte("image")}, nil
+}
+
+func (a *fakeApp) GetImageInfo(ctx context.Context, width, height int, bucket string, key string) (*internalApp.Image, error) {
+	if a.info == nil {
+		return nil, internalApp.ErrImageCached
+	}
+
+	return a.info, nil
+}
+
+func (a *fakeApp) PurgeObject(ctx context.Context, bucket, key string) int {
+	a.purged = append(a.purged, bucket+"/"+key)
+	return 2
+}
+
+func (a *fakeApp) PurgePrefix(ctx context.Context, bucket, prefix string) int {
+	a.purged = append(a.purged, bucket+"/"+prefix+"*")
+	return 3
+}
+
+func (a *fakeApp) CacheStats() internalCache.Stats {
+	return internalCache.Stats{Name: "disk", Hits: 5, Misses: 2, Entries: 3}
+}
+
+func (a *fakeApp) StartWarmup(ctx context.Context, request internalApp.WarmupRequest) (internalApp.WarmupJob, error) {
+	if len(request.Targets) == 0 && request.Bucket == "" {
+		return internalApp.WarmupJob{}, internalApp.ErrWarmupRequest
+	}
+	a.warmup = &request
+	a.jobs = ctx
+
+	return internalApp.WarmupJob{ID: "abc", Status: internalApp.WarmupStatusRunning, Total: len(request.Targets)}, nil
+}
+
+func (a *fakeApp) GetWarmupJob(id string) (internalApp.WarmupJob, bool) {
+	if id != "abc" {
+		return internalApp.WarmupJob{}, false
+	}
+
+	return internalApp.WarmupJob{ID: id, Status: internalApp.WarmupStatusDone, Total: 1, Processed: 1}, true
+}
+
+func (a *fakeApp) HandleObjectEvents(ctx context.Context, events []internalApp.ObjectEvent) internalApp.ObjectEventsResult {
+	a.events = append(a.events, events...)
+
+	return internalApp.ObjectEventsResult{Purged: len(events)}
+}
+
+func (a *fakeApp) Wait() {
+	a.waited = true
+}
+
+func TestAdmin(t *testing.T) {
+	config := &internalConfig.Config{Admin: internalConfig.AdminConf{Token: "secret"}}
+
+	t.Run("unauthorized", func(t *testing.T) {
+		app := &fakeApp{}
+		server := New(config, fakeLogger{}, app, &fakeHealth{})
+
+		r := httptest.NewRequest(http.MethodDelete, "/admin/cache/images/a/1.jpg", nil)
+		r.Header.Set("Authorization", "Bearer wrong")
+		w := httptest.NewRecorder()
+		server.Server.Handler.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+		require.Empty(t, app.purged)
+	})
+
+	t.Run("purge object", func(t *testing.T) {
+		app := &fakeApp{}
+		server := New(config, fakeLogger{}, app, &fakeHealth{})
+
+		r := httptest.NewRequest(http.MethodDelete, "/admin/cache/images/a/1.jpg", nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		server.Server.Handler.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, []string{"images/a/1.jpg"}, app.purged)
+
+		var response PurgeResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		require.Equal(t, 2, response.Purged)
+	})
+
+	t.Run("purge prefix", func(t *testing.T) {
+		app := &fakeApp{}
+		server := New(config, fakeLogger{}, app, &fakeHealth{})
+
+		r := httptest.NewRequest(http.MethodDelete, "/admin/cache/images?prefix=a/", nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		server.Server.Handler.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, []string{"images/a/*"}, app.purged)
+	})
+
+	t.Run("purge bucket", func(t *testing.T) {
+		for target, expected := range map[string]int{
+			"/admin/cache/images":          http.StatusBadRequest,
+			"/admin/cache/images?all=1":    http.StatusBadRequest,
+			"/admin/cache/images?prefix=":  http.StatusOK,
+			"/admin/cache/images?all=true": http.StatusOK,
+		} {
+			app := &fakeApp{}
+			server := New(config, fakeLogger{}, app, &fakeHealth{})
+
+			r := httptest.NewRequest(http.MethodDelete, target, nil)
+			r.Header.Set("Authorization", "Bearer secret")
+			w := httptest.NewRecorder()
+			server.Server.Handler.ServeHTTP(w, r)
+
+			require.Equal(t, expected, w.Code, target)
+			if expected == http.StatusOK {
+				require.Equal(t, []string{"images/*"}, app.purged, target)
+			} else {
+				require.Empty(t, app.purged, target)
+			}
+		}
+	})
+
+	t.Run("cache stats", func(t *testing.T) {
+		app := &fakeApp{}
+		server := New(config, fakeLogger{}, app, &fakeHealth{})
+
+		r := httptest.NewRequest(http.MethodGet, "/admin/stats", nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		server.Server.Handler.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var stats internalCache.Stats
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&stats))
+		require.Equal(t, internalCache.Stats{Name: "disk", Hits: 5, Misses: 2, Entries: 3}, stats)
+	})
+
+	t.Run("warm-up", func(t *testing.T) {
+		app := &fakeApp{}
+		server := New(config, fakeLogger{}, app, &fakeHealth{})
+
+		body := `{"targets": [{"bucket": "images", "key": "a/1.jpg", "preset": "small"}]}`
+		r := httptest.NewRequest(http.MethodPost, "/admin/warmup", strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		server.Server.Handler.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusAccepted, w.Code)
+		require.Equal(t, []internalApp.WarmupTarget{{Bucket: "images", Key: "a/1.jpg", Preset: "small"}}, app.warmup.Targets)
+
+		var job internalApp.WarmupJob
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&job))
+		require.Equal(t, "abc", job.ID)
+
+		r = httptest.NewRequest(http.MethodGet, "/admin/warmup/abc", nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		w = httptest.NewRecorder()
+		server.Server.Handler.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&job))
+		require.Equal(t, internalApp.WarmupStatusDone, job.Status)
+
+		r = httptest.NewRequest(http.MethodGet, "/admin/warmup/def", nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		w = httptest.NewRecorder()
+		server.Server.Handler.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusNotFound, w.Code)
+
+		// Jobs are cancelled once the server is stopped, and revalidations are waited for.
+		require.NoError(t, app.jobs.Err())
+		require.NoError(t, server.Stop(context.Background()))
+		require.ErrorIs(t, app.jobs.Err(), context.Canceled)
+		require.True(t, app.waited)
+	})
+
+	t.Run("invalid warm-up", func(t *testing.T) {
+		app := &fakeApp{}
+		server := New(config, fakeLogger{}, app, &fakeHealth{})
+
+		for _, body := range []string{`{"targets": `, `{}`} {
+			r := httptest.NewRequest(http.MethodPost, "/admin/warmup", strings.NewReader(body))
+			r.Header.Set("Authorization", "Bearer secret")
+			w := httptest.NewRecorder()
+			server.Server.Handler.ServeHTTP(w, r)
+
+			require.Equal(t, http.StatusBadRequest, w.Code)
+		}
+		require.Nil(t, app.warmup)
+	})
+
+	t.Run("disabled without token", func(t *testing.T) {
+		app := &fakeApp{}
+		server := New(&internalConfig.Config{}, fakeLogger{}, app, &fakeHealth{})
+
+		r := httptest.NewRequest(http.MethodDelete, "/admin/cache/images/a/1.jpg", nil)
+		w := httptest.NewRecorder()
+		server.Server.Handler.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
@@ -0,0 +1,48 @@
+package http
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	internalApp "github.com/spendmail/s3_previewer/internal/app"
+)
+
+// isConditionalRequest checks whether request contains cache validators.
+func isConditionalRequest(r *http.Request) bool {
+	return r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != ""
+}
+
+// isNotModified evaluates If-None-Match and If-Modified-Since preconditions (RFC 7232, section 6).
+func isNotModified(r *http.Request, image *internalApp.Image) bool {
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		return image.ETag != "" && matchETag(ifNoneMatch, image.ETag)
+	}
+
+	ifModifiedSince := r.Header.Get("If-Modified-Since")
+	if ifModifiedSince == "" || image.LastModified.IsZero() {
+		return false
+	}
+
+	since, err := http.ParseTime(ifModifiedSince)
+	if err != nil {
+		return false
+	}
+
+	// Last-Modified has a second precision, so sub-second parts are ignored.
+	return !image.LastModified.Truncate(time.Second).After(since)
+}
+
+// matchETag performs a weak comparison of the entity tag against a list from If-None-Match header.
+func matchETag(list, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,48 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	internalApp "github.com/spendmail/s3_previewer/internal/app"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsNotModified(t *testing.T) {
+	lastModified := time.Date(2022, 7, 15, 10, 0, 0, 500, time.UTC)
+	image := &internalApp.Image{ETag: `"abc"`, LastModified: lastModified}
+
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		expected bool
+	}{
+		{"no validators", map[string]string{}, false},
+		{"matching etag", map[string]string{"If-None-Match": `"abc"`}, true},
+		{"matching etag in list", map[string]string{"If-None-Match": `"xyz", W/"abc"`}, true},
+		{"wildcard", map[string]string{"If-None-Match": "*"}, true},
+		{"different etag", map[string]string{"If-None-Match": `"xyz"`}, false},
+		{"not modified since", map[string]string{"If-Modified-Since": lastModified.Format(http.TimeFormat)}, true},
+		{"modified since", map[string]string{"If-Modified-Since": lastModified.Add(-time.Hour).Format(http.TimeFormat)}, false},
+		{"broken date", map[string]string{"If-Modified-Since": "yesterday"}, false},
+		{
+			"etag takes precedence over date",
+			map[string]string{"If-None-Match": `"xyz"`, "If-Modified-Since": lastModified.Format(http.TimeFormat)},
+			false,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for name, value := range tc.headers {
+				r.Header.Set(name, value)
+			}
+
+			require.Equal(t, tc.expected, isNotModified(r, image))
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	internalApp "github.com/spendmail/s3_previewer/internal/app"
+)
+
+const (
+	snsTypeNotification             = "Notification"
+	snsTypeSubscriptionConfirmation = "SubscriptionConfirmation"
+)
+
+// s3EventNotification is a payload of s3 event notifications, either delivered as is or wrapped into sns message.
+type s3EventNotification struct {
+	Type         string `json:"Type"`
+	Message      string `json:"Message"`
+	SubscribeURL string `json:"SubscribeURL"`
+	Records      []struct {
+		EventName string `json:"eventName"`
+		S3        struct {
+			Bucket struct {
+				Name string `json:"name"`
+			} `json:"bucket"`
+			Object struct {
+				Key string `json:"key"`
+			} `json:"object"`
+		} `json:"s3"`
+	} `json:"Records"`
+}
+
+// s3EventsHandler purges cached variants of objects changed in s3, and renders presets of uploaded ones.
+func (h *Handler) s3EventsHandler(w http.ResponseWriter, r *http.Request) {
+	var notification s3EventNotification
+	if err := json.NewDecoder(r.Body).Decode(&notification); err != nil {
+		h.Logger.InfoContext(r.Context(), fmt.Errorf("%w: %s", ErrRequestParse, err))
+		http.Error(w, ErrRequestParse.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch notification.Type {
+	case snsTypeSubscriptionConfirmation:
+		// Subscription is confirmed by an operator, so that the server doesn't visit urls given by requests.
+		h.Logger.InfoContext(r.Context(), fmt.Sprintf("sns subscription is to be confirmed at %s", notification.SubscribeURL))
+		w.WriteHeader(http.StatusOK)
+		return
+	case snsTypeNotification:
+		message := notification.Message
+		notification = s3EventNotification{}
+		if err := json.Unmarshal([]byte(message), &notification); err != nil {
+			h.Logger.InfoContext(r.Context(), fmt.Errorf("%w: %s", ErrRequestParse, err))
+			http.Error(w, ErrRequestParse.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	events := make([]internalApp.ObjectEvent, 0, len(notification.Records))
+	for _, record := range notification.Records {
+		event := internalApp.ObjectEvent{Bucket: record.S3.Bucket.Name}
+
+		switch {
+		case strings.HasPrefix(record.EventName, "ObjectCreated:"):
+			event.Type = internalApp.ObjectEventCreated
+		case strings.HasPrefix(record.EventName, "ObjectRemoved:"),
+			strings.HasPrefix(record.EventName, "LifecycleExpiration:"):
+			event.Type = internalApp.ObjectEventRemoved
+		default:
+			continue
+		}
+
+		// Keys are url-encoded in notifications.
+		key, err := url.QueryUnescape(record.S3.Object.Key)
+		if err != nil {
+			h.Logger.InfoContext(r.Context(), fmt.Errorf("%w: %s", ErrRequestParse, err))
+			http.Error(w, ErrRequestParse.Error(), http.StatusBadRequest)
+			return
+		}
+		event.Key = key
+
+		events = append(events, event)
+	}
+
+	// Rendering outlives the request, so it doesn't inherit the request context.
+	result := h.App.HandleObjectEvents(h.jobs, events)
+	h.Logger.InfoContext(r.Context(), fmt.Sprintf("%d s3 events are handled, %d cached variants purged", len(events), result.Purged))
+
+	h.sendJSON(w, http.StatusOK, result)
+}
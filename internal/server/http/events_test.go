@@ -0,0 +1,110 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	internalApp "github.com/spendmail/s3_previewer/internal/app"
+	internalConfig "github.com/spendmail/s3_previewer/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+const s3EventPayload = `{"Records": [
+	{"eventName": "ObjectCreated:Put", "s3": {"bucket": {"name": "images"}, "object": {"key": "a/new+photo%281%29.jpg"}}},
+	{"eventName": "ObjectRemoved:Delete", "s3": {"bucket": {"name": "images"}, "object": {"key": "a/old.jpg"}}},
+	{"eventName": "ObjectRestore:Completed", "s3": {"bucket": {"name": "images"}, "object": {"key": "a/archived.jpg"}}}
+]}`
+
+func TestS3Events(t *testing.T) {
+	config := &internalConfig.Config{Events: internalConfig.EventsConf{Token: "secret"}}
+	expected := []internalApp.ObjectEvent{
+		{Type: internalApp.ObjectEventCreated, Bucket: "images", Key: "a/new photo(1).jpg"},
+		{Type: internalApp.ObjectEventRemoved, Bucket: "images", Key: "a/old.jpg"},
+	}
+
+	t.Run("notification", func(t *testing.T) {
+		app := &fakeApp{}
+		server := New(config, fakeLogger{}, app, &fakeHealth{})
+
+		r := httptest.NewRequest(http.MethodPost, "/events/s3", strings.NewReader(s3EventPayload))
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		server.Server.Handler.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, expected, app.events)
+
+		var result internalApp.ObjectEventsResult
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&result))
+		require.Equal(t, 2, result.Purged)
+	})
+
+	t.Run("sns notification", func(t *testing.T) {
+		app := &fakeApp{}
+		server := New(config, fakeLogger{}, app, &fakeHealth{})
+
+		message, err := json.Marshal(map[string]string{"Type": "Notification", "Message": s3EventPayload})
+		require.NoError(t, err)
+
+		r := httptest.NewRequest(http.MethodPost, "/events/s3", strings.NewReader(string(message)))
+		r.SetBasicAuth("sns", "secret")
+		w := httptest.NewRecorder()
+		server.Server.Handler.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, expected, app.events)
+	})
+
+	t.Run("sns subscription confirmation", func(t *testing.T) {
+		app := &fakeApp{}
+		server := New(config, fakeLogger{}, app, &fakeHealth{})
+
+		body := `{"Type": "SubscriptionConfirmation", "SubscribeURL": "https://sns.example.com/confirm"}`
+		r := httptest.NewRequest(http.MethodPost, "/events/s3", strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		server.Server.Handler.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Empty(t, app.events)
+	})
+
+	t.Run("unauthorized", func(t *testing.T) {
+		app := &fakeApp{}
+		server := New(config, fakeLogger{}, app, &fakeHealth{})
+
+		r := httptest.NewRequest(http.MethodPost, "/events/s3", strings.NewReader(s3EventPayload))
+		r.SetBasicAuth("sns", "wrong")
+		w := httptest.NewRecorder()
+		server.Server.Handler.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+		require.Empty(t, app.events)
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		app := &fakeApp{}
+		server := New(config, fakeLogger{}, app, &fakeHealth{})
+
+		r := httptest.NewRequest(http.MethodPost, "/events/s3", strings.NewReader(`{"Records": `))
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		server.Server.Handler.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("disabled without token", func(t *testing.T) {
+		app := &fakeApp{}
+		server := New(&internalConfig.Config{}, fakeLogger{}, app, &fakeHealth{})
+
+		r := httptest.NewRequest(http.MethodPost, "/events/s3", strings.NewReader(s3EventPayload))
+		w := httptest.NewRecorder()
+		server.Server.Handler.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
@@ -0,0 +1,32 @@
+package http
+
+import (
+	"net/http"
+
+	internalHealth "github.com/spendmail/s3_previewer/internal/health"
+)
+
+// livenessHandler reports that the process is able to serve requests.
+func (h *Handler) livenessHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if _, err := w.Write([]byte(internalHealth.StatusOK)); err != nil {
+		h.Logger.Error(err)
+	}
+}
+
+// readinessHandler reports results of readiness checks, with 503 status while any of them fails.
+func (h *Handler) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	report := h.Health.Ready(r.Context())
+
+	status := http.StatusOK
+	if !report.Ready {
+		status = http.StatusServiceUnavailable
+	}
+
+	h.sendJSON(w, status, report)
+}
+
+// versionHandler reports the build of previewer.
+func (h *Handler) versionHandler(w http.ResponseWriter, r *http.Request) {
+	h.sendJSON(w, http.StatusOK, h.Health.Version())
+}
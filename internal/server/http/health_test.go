@@ -0,0 +1,66 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	internalConfig "github.com/spendmail/s3_previewer/internal/config"
+	internalHealth "github.com/spendmail/s3_previewer/internal/health"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeHealth struct {
+	report internalHealth.Report
+}
+
+func (h *fakeHealth) Ready(ctx context.Context) internalHealth.Report {
+	return h.report
+}
+
+func (h *fakeHealth) Version() internalHealth.Version {
+	return internalHealth.Version{Release: "v1.2.0", BuildDate: "2022-06-01", GitHash: "abc"}
+}
+
+func TestHealth(t *testing.T) {
+	t.Run("liveness", func(t *testing.T) {
+		server := New(&internalConfig.Config{}, fakeLogger{}, &fakeApp{}, &fakeHealth{})
+
+		r := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+		w := httptest.NewRecorder()
+		server.Server.Handler.ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "ok", w.Body.String())
+	})
+
+	t.Run("readiness", func(t *testing.T) {
+		health := &fakeHealth{report: internalHealth.Report{Ready: true, Checks: map[string]string{"s3:images": "ok"}}}
+		server := New(&internalConfig.Config{}, fakeLogger{}, &fakeApp{}, health)
+
+		r := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+		w := httptest.NewRecorder()
+		server.Server.Handler.ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		health.report = internalHealth.Report{Checks: map[string]string{"cache_restored": "cache is being restored from filesystem"}}
+		w = httptest.NewRecorder()
+		server.Server.Handler.ServeHTTP(w, r)
+		require.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+		var report internalHealth.Report
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&report))
+		require.Equal(t, health.report, report)
+	})
+
+	t.Run("version", func(t *testing.T) {
+		server := New(&internalConfig.Config{}, fakeLogger{}, &fakeApp{}, &fakeHealth{})
+
+		r := httptest.NewRequest(http.MethodGet, "/version", nil)
+		w := httptest.NewRecorder()
+		server.Server.Handler.ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.JSONEq(t, `{"Release":"v1.2.0","BuildDate":"2022-06-01","GitHash":"abc"}`, w.Body.String())
+	})
+}
@@ -0,0 +1,82 @@
+package http
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	internalLogger "github.com/spendmail/s3_previewer/internal/logger"
+)
+
+// Request IDs given by clients are accepted up to this length.
+const maxRequestIDLength = 128
+
+// requestIDMiddleware puts the request ID into the request context and the response headers.
+// ID is taken from the request, or generated when the client doesn't send a valid one.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(RequestIDHeader)
+		if !isValidRequestID(requestID) {
+			requestID = newRequestID()
+		}
+
+		w.Header().Set(RequestIDHeader, requestID)
+		next.ServeHTTP(w, r.WithContext(internalLogger.ContextWithRequestID(r.Context(), requestID)))
+	})
+}
+
+// accessLogMiddleware writes an access log entry for every request, including unmatched ones.
+func accessLogMiddleware(logger Logger) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(recorder, r)
+
+			logger.Access(r.Context(), map[string]interface{}{
+				"method":       r.Method,
+				"path":         r.URL.Path,
+				"status":       recorder.status,
+				"bytes":        recorder.bytes,
+				"duration_ms":  float64(time.Since(start)) / float64(time.Millisecond),
+				"cache_status": w.Header().Get(CacheStatusHeader),
+				"client_ip":    clientIP(r),
+			})
+		})
+	}
+}
+
+// isValidRequestID reports whether the request ID given by a client could be logged as is.
+func isValidRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+
+	for _, c := range requestID {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// clientIP returns address of the peer, the one of proxy when previewer is behind it.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
@@ -0,0 +1,76 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	internalApp "github.com/spendmail/s3_previewer/internal/app"
+	internalConfig "github.com/spendmail/s3_previewer/internal/config"
+	internalLogger "github.com/spendmail/s3_previewer/internal/logger"
+	"github.com/stretchr/testify/require"
+)
+
+type accessLogger struct {
+	fakeLogger
+	requestIDs []string
+	entries    []map[string]interface{}
+}
+
+func (l *accessLogger) Access(ctx context.Context, fields map[string]interface{}) {
+	l.requestIDs = append(l.requestIDs, internalLogger.RequestID(ctx))
+	l.entries = append(l.entries, fields)
+}
+
+func TestLogging(t *testing.T) {
+	t.Run("access log", func(t *testing.T) {
+		logger := &accessLogger{}
+		app := &fakeApp{image: &internalApp.Image{Bytes: []byte("image"), CacheStatus: internalApp.CacheStatusHit}}
+		server := New(&internalConfig.Config{}, logger, app, &fakeHealth{})
+
+		r := httptest.NewRequest(http.MethodGet, "/resize/100/100/images/cat.png", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		w := httptest.NewRecorder()
+		server.Server.Handler.ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, internalApp.CacheStatusHit, w.Header().Get(CacheStatusHeader))
+
+		// Requests of unknown routes are logged too.
+		r = httptest.NewRequest(http.MethodGet, "/unknown", nil)
+		w = httptest.NewRecorder()
+		server.Server.Handler.ServeHTTP(w, r)
+		require.Equal(t, http.StatusNotFound, w.Code)
+
+		require.Len(t, logger.entries, 2)
+		entry := logger.entries[0]
+		require.Equal(t, http.MethodGet, entry["method"])
+		require.Equal(t, "/resize/100/100/images/cat.png", entry["path"])
+		require.Equal(t, http.StatusOK, entry["status"])
+		require.Equal(t, len("image"), entry["bytes"])
+		require.Equal(t, internalApp.CacheStatusHit, entry["cache_status"])
+		require.Equal(t, "192.0.2.1", entry["client_ip"])
+		require.Contains(t, entry, "duration_ms")
+		require.Equal(t, http.StatusNotFound, logger.entries[1]["status"])
+	})
+
+	t.Run("request id", func(t *testing.T) {
+		logger := &accessLogger{}
+		server := New(&internalConfig.Config{}, logger, &fakeApp{}, &fakeHealth{})
+
+		r := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+		r.Header.Set(RequestIDHeader, "given-id")
+		w := httptest.NewRecorder()
+		server.Server.Handler.ServeHTTP(w, r)
+		require.Equal(t, "given-id", w.Header().Get(RequestIDHeader))
+
+		// Malformed IDs are replaced by generated ones.
+		r.Header.Set(RequestIDHeader, "given id\n")
+		w = httptest.NewRecorder()
+		server.Server.Handler.ServeHTTP(w, r)
+		generated := w.Header().Get(RequestIDHeader)
+		require.Len(t, generated, 32)
+
+		require.Equal(t, []string{"given-id", generated}, logger.requestIDs)
+	})
+}
@@ -0,0 +1,49 @@
+package http
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	internalConfig "github.com/spendmail/s3_previewer/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Run("requests by route", func(t *testing.T) {
+		server := New(&internalConfig.Config{}, fakeLogger{}, &fakeApp{}, &fakeHealth{})
+
+		r := httptest.NewRequest(http.MethodGet, "/resize/100/100/images/cat.png", nil)
+		w := httptest.NewRecorder()
+		server.Server.Handler.ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		r = httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		w = httptest.NewRecorder()
+		server.Server.Handler.ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		body, err := io.ReadAll(w.Body)
+		require.NoError(t, err)
+		require.Contains(t, string(body),
+			`previewer_http_requests_total{method="GET",route="/resize/{width:[0-9]+}/{height:[0-9]+}/{bucket:[a-zA-Z-]+}/{key:.+}",status="200"}`)
+		require.Contains(t, string(body), "previewer_http_requests_in_flight 1")
+		require.Contains(t, string(body), "go_goroutines")
+	})
+
+	t.Run("token", func(t *testing.T) {
+		config := &internalConfig.Config{Metrics: internalConfig.MetricsConf{Token: "secret"}}
+		server := New(config, fakeLogger{}, &fakeApp{}, &fakeHealth{})
+
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		w := httptest.NewRecorder()
+		server.Server.Handler.ServeHTTP(w, r)
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+
+		r.Header.Set("Authorization", "Bearer secret")
+		w = httptest.NewRecorder()
+		server.Server.Handler.ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+	})
+}
@@ -2,6 +2,7 @@ package http
 
 import (
 	"context"
+	"expvar"
 	"fmt"
 	"net"
 	"net/http"
@@ -9,19 +10,45 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
+	internalApp "github.com/spendmail/s3_previewer/internal/app"
+	internalCache "github.com/spendmail/s3_previewer/internal/cache"
+	internalHealth "github.com/spendmail/s3_previewer/internal/health"
+	internalMetrics "github.com/spendmail/s3_previewer/internal/metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
-	URLResizePattern = "/resize/{width:[0-9]+}/{height:[0-9]+}/{bucket:[a-zA-Z-]+}/{key:.+}"
-	WidthField       = "width"
-	HeightField      = "height"
-	BucketField      = "bucket"
-	KeyField         = "key"
+	URLResizePattern           = "/resize/{width:[0-9]+}/{height:[0-9]+}/{bucket:[a-zA-Z-]+}/{key:.+}"
+	URLAdminPurgeObjectPattern = "/admin/cache/{bucket:[a-zA-Z-]+}/{key:.+}"
+	URLAdminPurgePrefixPattern = "/admin/cache/{bucket:[a-zA-Z-]+}"
+	URLAdminStatsPattern       = "/admin/stats"
+	URLAdminWarmupPattern      = "/admin/warmup"
+	URLAdminWarmupJobPattern   = "/admin/warmup/{id:[0-9a-f]+}"
+	URLAdminVarsPattern        = "/debug/vars"
+	URLS3EventsPattern         = "/events/s3"
+	URLMetricsPattern          = "/metrics"
+	URLLivenessPattern         = "/healthz"
+	URLReadinessPattern        = "/readyz"
+	URLVersionPattern          = "/version"
+	RequestIDHeader            = "X-Request-ID"
+	CacheStatusHeader          = "X-Cache-Status"
+	WidthField                 = "width"
+	HeightField                = "height"
+	BucketField                = "bucket"
+	KeyField                   = "key"
+	JobIDField                 = "id"
+	PrefixParameter            = "prefix"
+	AllParameter               = "all"
 )
 
 type Config interface {
 	GetHTTPHost() string
 	GetHTTPPort() string
+	GetHTTPCacheControl() string
+	GetAdminToken() string
+	GetEventsToken() string
+	GetMetricsToken() string
 }
 
 type Logger interface {
@@ -29,15 +56,34 @@ type Logger interface {
 	Info(args ...interface{})
 	Warn(args ...interface{})
 	Error(args ...interface{})
+	InfoContext(ctx context.Context, args ...interface{})
+	ErrorContext(ctx context.Context, args ...interface{})
+	Access(ctx context.Context, fields map[string]interface{})
 }
 
 type Application interface {
-	ResizeImageByURL(width, height int, bucket string, key string, headers map[string][]string) ([]byte, error)
+	ResizeImageByURL(ctx context.Context, width, height int, bucket string, key string, headers map[string][]string) (*internalApp.Image, error)
+	GetImageInfo(ctx context.Context, width, height int, bucket string, key string) (*internalApp.Image, error)
+	PurgeObject(ctx context.Context, bucket, key string) int
+	PurgePrefix(ctx context.Context, bucket, prefix string) int
+	CacheStats() internalCache.Stats
+	StartWarmup(ctx context.Context, request internalApp.WarmupRequest) (internalApp.WarmupJob, error)
+	GetWarmupJob(id string) (internalApp.WarmupJob, bool)
+	HandleObjectEvents(ctx context.Context, events []internalApp.ObjectEvent) internalApp.ObjectEventsResult
+	Wait()
+}
+
+type Health interface {
+	Ready(ctx context.Context) internalHealth.Report
+	Version() internalHealth.Version
 }
 
 type Server struct {
 	Logger Logger
 	Server *http.Server
+	App    Application
+	// cancel stops background jobs started by requests.
+	cancel context.CancelFunc
 }
 
 var (
@@ -45,31 +91,74 @@ var (
 	ErrParameterParseHeight = errors.New("unable to parse image height")
 	ErrResizeImage          = errors.New("unable to resize an image")
 	ErrResponseWrite        = errors.New("unable to write a response")
+	ErrRequestParse         = errors.New("unable to parse a request")
+	ErrPrefixRequired       = errors.New("prefix parameter is required, or all=true to purge the whole bucket")
 )
 
 type Handler struct {
-	App    Application
-	Logger Logger
+	App          Application
+	Health       Health
+	Logger       Logger
+	CacheControl string
+	// jobs is a context of background jobs, which outlive requests, but not the server.
+	jobs context.Context
 }
 
 // New is HTTP service constructor.
-func New(config Config, logger Logger, app Application) *Server {
+func New(config Config, logger Logger, app Application, health Health) *Server {
+	jobs, cancel := context.WithCancel(context.Background())
+
 	handler := &Handler{
-		App:    app,
-		Logger: logger,
+		App:          app,
+		Health:       health,
+		Logger:       logger,
+		CacheControl: config.GetHTTPCacheControl(),
+		jobs:         jobs,
 	}
 
 	router := mux.NewRouter()
+	router.Use(metricsMiddleware, tracingMiddleware)
 	router.HandleFunc(URLResizePattern, handler.resizeHandler).Methods(http.MethodGet)
+	router.HandleFunc(URLLivenessPattern, handler.livenessHandler).Methods(http.MethodGet)
+	router.HandleFunc(URLReadinessPattern, handler.readinessHandler).Methods(http.MethodGet)
+	router.HandleFunc(URLVersionPattern, handler.versionHandler).Methods(http.MethodGet)
+
+	// Metrics endpoint is public unless token is configured.
+	metrics := router.NewRoute().Subrouter()
+	if token := config.GetMetricsToken(); token != "" {
+		metrics.Use(tokenAuthMiddleware(token))
+	}
+	metrics.Handle(URLMetricsPattern, internalMetrics.Handler()).Methods(http.MethodGet)
+
+	// Admin endpoints are available only when token is configured.
+	if token := config.GetAdminToken(); token != "" {
+		admin := router.NewRoute().Subrouter()
+		admin.Use(tokenAuthMiddleware(token))
+		admin.HandleFunc(URLAdminPurgeObjectPattern, handler.purgeObjectHandler).Methods(http.MethodDelete)
+		admin.HandleFunc(URLAdminPurgePrefixPattern, handler.purgePrefixHandler).Methods(http.MethodDelete)
+		admin.HandleFunc(URLAdminStatsPattern, handler.statsHandler).Methods(http.MethodGet)
+		admin.HandleFunc(URLAdminWarmupPattern, handler.startWarmupHandler).Methods(http.MethodPost)
+		admin.HandleFunc(URLAdminWarmupJobPattern, handler.warmupJobHandler).Methods(http.MethodGet)
+		admin.Handle(URLAdminVarsPattern, expvar.Handler()).Methods(http.MethodGet)
+	}
+
+	// S3 events endpoint has its own token, as it's given to the notification senders.
+	if token := config.GetEventsToken(); token != "" {
+		events := router.NewRoute().Subrouter()
+		events.Use(tokenAuthMiddleware(token))
+		events.HandleFunc(URLS3EventsPattern, handler.s3EventsHandler).Methods(http.MethodPost)
+	}
 
 	server := &http.Server{
 		Addr:    net.JoinHostPort(config.GetHTTPHost(), config.GetHTTPPort()),
-		Handler: router,
+		Handler: requestIDMiddleware(accessLogMiddleware(logger)(router)),
 	}
 
 	return &Server{
 		Logger: logger,
 		Server: server,
+		App:    app,
+		cancel: cancel,
 	}
 }
 
@@ -77,36 +166,102 @@ func New(config Config, logger Logger, app Application) *Server {
 func (h *Handler) resizeHandler(w http.ResponseWriter, r *http.Request) {
 	width, err := strconv.Atoi(mux.Vars(r)[WidthField])
 	if err != nil {
-		SendBadGatewayStatus(w, h, fmt.Errorf("%w: %s", ErrParameterParseWidth, err))
+		SendBadGatewayStatus(w, r, h, fmt.Errorf("%w: %s", ErrParameterParseWidth, err))
 		return
 	}
 
 	height, err := strconv.Atoi(mux.Vars(r)[HeightField])
 	if err != nil {
-		SendBadGatewayStatus(w, h, fmt.Errorf("%w: %s", ErrParameterParseHeight, err))
+		SendBadGatewayStatus(w, r, h, fmt.Errorf("%w: %s", ErrParameterParseHeight, err))
 		return
 	}
 
-	bytes, err := h.App.ResizeImageByURL(width, height, mux.Vars(r)[BucketField], mux.Vars(r)[KeyField], r.Header)
+	bucket, key := mux.Vars(r)[BucketField], mux.Vars(r)[KeyField]
+
+	trace.SpanFromContext(r.Context()).SetAttributes(
+		attribute.Int("image.width", width),
+		attribute.Int("image.height", height),
+		attribute.String("s3.bucket", bucket),
+		attribute.String("s3.key", key),
+	)
+
+	// Conditional requests of variants which aren't cached are answered using source object metadata, without resizing.
+	if isConditionalRequest(r) {
+		info, err := h.App.GetImageInfo(r.Context(), width, height, bucket, key)
+		if err == nil && isNotModified(r, info) {
+			w.Header().Set(CacheStatusHeader, info.CacheStatus)
+			h.setValidators(w, info)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	// Revalidations started by the request run in background under the jobs context.
+	ctx := internalApp.ContextWithJobs(r.Context(), h.jobs)
+	image, err := h.App.ResizeImageByURL(ctx, width, height, bucket, key, r.Header)
 	if err != nil {
-		SendBadGatewayStatus(w, h, err)
+		SendBadGatewayStatus(w, r, h, err)
+		return
+	}
+
+	if image.Warning != "" {
+		w.Header().Set("Warning", image.Warning)
+	}
+
+	if image.CacheStatus != "" {
+		w.Header().Set(CacheStatusHeader, image.CacheStatus)
+	}
+
+	// Conditional requests of cached variants are answered from the revalidated variant, so the source is requested only once.
+	if isConditionalRequest(r) && isNotModified(r, image) {
+		h.setValidators(w, image)
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 
-	w.Header().Set("Content-Type", http.DetectContentType(bytes))
-	w.Header().Set("Content-Length", strconv.Itoa(len(bytes)))
-	if _, err := w.Write(bytes); err != nil {
-		h.Logger.Error(fmt.Errorf("%w: %s", ErrResizeImage, err.Error()))
+	// Presigned urls expire shortly, so redirects must not be cached.
+	if image.RedirectURL != "" {
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, image.RedirectURL, http.StatusFound)
+		return
+	}
+
+	// Content type is sniffed only for images which metadata doesn't have it.
+	contentType := image.ContentType
+	if contentType == "" {
+		contentType = http.DetectContentType(image.Bytes)
+	}
+
+	h.setValidators(w, image)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(image.Bytes)))
+	if _, err := w.Write(image.Bytes); err != nil {
+		h.Logger.ErrorContext(r.Context(), fmt.Errorf("%w: %s", ErrResizeImage, err.Error()))
+	}
+}
+
+// setValidators sets caching related headers.
+func (h *Handler) setValidators(w http.ResponseWriter, image *internalApp.Image) {
+	if h.CacheControl != "" {
+		w.Header().Set("Cache-Control", h.CacheControl)
+	}
+
+	if image.ETag != "" {
+		w.Header().Set("ETag", image.ETag)
+	}
+
+	if !image.LastModified.IsZero() {
+		w.Header().Set("Last-Modified", image.LastModified.UTC().Format(http.TimeFormat))
 	}
 }
 
 // SendBadGatewayStatus sends http.StatusBadGateway response with custom message.
-func SendBadGatewayStatus(w http.ResponseWriter, h *Handler, err error) {
+func SendBadGatewayStatus(w http.ResponseWriter, r *http.Request, h *Handler, err error) {
 	w.WriteHeader(http.StatusBadGateway)
 	if n, e := w.Write([]byte(err.Error())); e != nil {
-		h.Logger.Error(fmt.Errorf("%w: trying to write %d bytes: %s", ErrResponseWrite, n, e.Error()))
+		h.Logger.ErrorContext(r.Context(), fmt.Errorf("%w: trying to write %d bytes: %s", ErrResponseWrite, n, e.Error()))
 	}
-	h.Logger.Error(err.Error())
+	h.Logger.ErrorContext(r.Context(), err.Error())
 }
 
 // Start launches a HTTP server.
@@ -114,7 +269,12 @@ func (s *Server) Start() error {
 	return s.Server.ListenAndServe()
 }
 
-// Stop suspends HTTP server.
+// Stop suspends HTTP server, cancels background jobs started by its requests and waits for revalidations.
 func (s *Server) Stop(ctx context.Context) error {
-	return s.Server.Shutdown(ctx)
+	err := s.Server.Shutdown(ctx)
+
+	s.cancel()
+	s.App.Wait()
+
+	return err
 }
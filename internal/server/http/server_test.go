@@ -0,0 +1,95 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	internalApp "github.com/spendmail/s3_previewer/internal/app"
+	internalConfig "github.com/spendmail/s3_previewer/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResize(t *testing.T) {
+	config := &internalConfig.Config{HTTP: internalConfig.HTTPConf{CacheControl: "public, max-age=86400"}}
+
+	t.Run("proxied image", func(t *testing.T) {
+		app := &fakeApp{image: &internalApp.Image{Bytes: []byte("image"), ContentType: "image/png", ETag: `"etag"`}}
+		server := New(config, fakeLogger{}, app, &fakeHealth{})
+
+		r := httptest.NewRequest(http.MethodGet, "/resize/100/100/images/cat.png", nil)
+		w := httptest.NewRecorder()
+		server.Server.Handler.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, "image/png", w.Header().Get("Content-Type"))
+		require.Equal(t, `"etag"`, w.Header().Get("ETag"))
+		require.Equal(t, "public, max-age=86400", w.Header().Get("Cache-Control"))
+		require.Equal(t, "image", w.Body.String())
+	})
+
+	t.Run("not modified image", func(t *testing.T) {
+		app := &fakeApp{image: &internalApp.Image{Bytes: []byte("image"), ETag: `"etag"`, CacheStatus: internalApp.CacheStatusRevalidated}}
+		server := New(config, fakeLogger{}, app, &fakeHealth{})
+
+		r := httptest.NewRequest(http.MethodGet, "/resize/100/100/images/cat.png", nil)
+		r.Header.Set("If-None-Match", `"etag"`)
+		w := httptest.NewRecorder()
+		server.Server.Handler.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusNotModified, w.Code)
+		require.Equal(t, `"etag"`, w.Header().Get("ETag"))
+		require.Equal(t, internalApp.CacheStatusRevalidated, w.Header().Get(CacheStatusHeader))
+		require.Empty(t, w.Body.String())
+	})
+
+	t.Run("not modified image which isn't cached", func(t *testing.T) {
+		app := &fakeApp{info: &internalApp.Image{ETag: `"etag"`, CacheStatus: internalApp.CacheStatusMiss}}
+		server := New(config, fakeLogger{}, app, &fakeHealth{})
+
+		r := httptest.NewRequest(http.MethodGet, "/resize/100/100/images/cat.png", nil)
+		r.Header.Set("If-None-Match", `"etag"`)
+		w := httptest.NewRecorder()
+		server.Server.Handler.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusNotModified, w.Code)
+		require.Equal(t, `"etag"`, w.Header().Get("ETag"))
+		require.Equal(t, internalApp.CacheStatusMiss, w.Header().Get(CacheStatusHeader))
+		require.Equal(t, 0, app.resizes)
+
+		// Modified variant is rendered.
+		r = httptest.NewRequest(http.MethodGet, "/resize/100/100/images/cat.png", nil)
+		r.Header.Set("If-None-Match", `"previous"`)
+		w = httptest.NewRecorder()
+		server.Server.Handler.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, 1, app.resizes)
+	})
+
+	t.Run("stale image", func(t *testing.T) {
+		app := &fakeApp{image: &internalApp.Image{Bytes: []byte("image"), Warning: internalApp.WarningRevalidationFailed}}
+		server := New(config, fakeLogger{}, app, &fakeHealth{})
+
+		r := httptest.NewRequest(http.MethodGet, "/resize/100/100/images/cat.png", nil)
+		w := httptest.NewRecorder()
+		server.Server.Handler.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, internalApp.WarningRevalidationFailed, w.Header().Get("Warning"))
+	})
+
+	t.Run("redirected image", func(t *testing.T) {
+		url := "https://variants.s3.amazonaws.com/variants/abc?X-Amz-Signature=signature"
+		app := &fakeApp{image: &internalApp.Image{RedirectURL: url, ETag: `"etag"`}}
+		server := New(config, fakeLogger{}, app, &fakeHealth{})
+
+		r := httptest.NewRequest(http.MethodGet, "/resize/100/100/images/cat.png", nil)
+		w := httptest.NewRecorder()
+		server.Server.Handler.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusFound, w.Code)
+		require.Equal(t, url, w.Header().Get("Location"))
+		require.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	})
+}
@@ -0,0 +1,109 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	defaultServiceName = "previewer"
+	shutdownTimeout    = 5 * time.Second
+	// instrumentationPrefix is a prefix of tracer names, which are named after the instrumented packages.
+	instrumentationPrefix = "github.com/spendmail/s3_previewer/internal/"
+)
+
+var ErrExporter = errors.New("unable to create trace exporter")
+
+type Config interface {
+	GetTracingEndpoint() string
+	GetTracingInsecure() bool
+	GetTracingServiceName() string
+	GetTracingSampleRatio() float64
+}
+
+type Logger interface {
+	Debug(args ...interface{})
+	Info(args ...interface{})
+	Warn(args ...interface{})
+	Error(args ...interface{})
+}
+
+// Tracer returns a tracer of the internal package. It is looked up on every use, as the global provider can be replaced.
+func Tracer(pkg string) trace.Tracer {
+	return otel.Tracer(instrumentationPrefix + pkg)
+}
+
+// End records the error, if any, and ends the span.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Tracing exports spans of the application to an OTLP collector.
+type Tracing struct {
+	logger   Logger
+	provider *sdktrace.TracerProvider
+}
+
+// New is a tracing constructor. It installs global tracer provider exporting spans over OTLP/HTTP,
+// and W3C trace context propagator. Spans are not recorded when endpoint is empty.
+func New(config Config, logger Logger) (*Tracing, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if config.GetTracingEndpoint() == "" {
+		return &Tracing{logger: logger}, nil
+	}
+
+	options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(config.GetTracingEndpoint())}
+	if config.GetTracingInsecure() {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(), options...)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrExporter, err)
+	}
+
+	serviceName := config.GetTracingServiceName()
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(serviceName))),
+		// Incoming requests which are sampled by the caller are always traced.
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.GetTracingSampleRatio()))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return &Tracing{logger: logger, provider: provider}, nil
+}
+
+// Close exports pending spans.
+func (t *Tracing) Close() {
+	if t.provider == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := t.provider.Shutdown(ctx); err != nil {
+		t.logger.Error(err)
+	}
+}
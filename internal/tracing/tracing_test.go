@@ -0,0 +1,112 @@
+package tracing
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+type fakeLogger struct{}
+
+func (fakeLogger) Debug(args ...interface{}) {}
+func (fakeLogger) Info(args ...interface{})  {}
+func (fakeLogger) Warn(args ...interface{})  {}
+func (fakeLogger) Error(args ...interface{}) {}
+
+type fakeConfig struct {
+	endpoint string
+}
+
+func (c fakeConfig) GetTracingEndpoint() string     { return c.endpoint }
+func (c fakeConfig) GetTracingInsecure() bool       { return true }
+func (c fakeConfig) GetTracingServiceName() string  { return "previewer-test" }
+func (c fakeConfig) GetTracingSampleRatio() float64 { return 1 }
+
+// collector is an OTLP/HTTP collector stand-in, remembering names of received spans.
+type collector struct {
+	mutex    sync.Mutex
+	services []string
+	spans    []string
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil || r.URL.Path != "/v1/traces" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	request := &coltracepb.ExportTraceServiceRequest{}
+	if err := proto.Unmarshal(body, request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, resourceSpans := range request.ResourceSpans {
+		for _, attribute := range resourceSpans.Resource.Attributes {
+			if attribute.Key == "service.name" {
+				c.services = append(c.services, attribute.Value.GetStringValue())
+			}
+		}
+
+		for _, scopeSpans := range resourceSpans.ScopeSpans {
+			for _, span := range scopeSpans.Spans {
+				c.spans = append(c.spans, span.Name)
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestTracing(t *testing.T) {
+	t.Run("export", func(t *testing.T) {
+		collector := &collector{}
+		server := httptest.NewServer(collector)
+		defer server.Close()
+
+		tracing, err := New(fakeConfig{endpoint: strings.TrimPrefix(server.URL, "http://")}, fakeLogger{})
+		require.NoError(t, err)
+
+		ctx, parent := otel.Tracer("test").Start(context.Background(), "parent")
+		_, child := otel.Tracer("test").Start(ctx, "child")
+		child.End()
+		parent.End()
+
+		tracing.Close()
+
+		collector.mutex.Lock()
+		defer collector.mutex.Unlock()
+		require.ElementsMatch(t, []string{"parent", "child"}, collector.spans)
+		require.Contains(t, collector.services, "previewer-test")
+	})
+
+	t.Run("propagation without exporter", func(t *testing.T) {
+		tracing, err := New(fakeConfig{}, fakeLogger{})
+		require.NoError(t, err)
+		defer tracing.Close()
+
+		header := http.Header{}
+		header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+		ctx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.HeaderCarrier(header))
+		_, span := otel.Tracer("test").Start(ctx, "span")
+		defer span.End()
+
+		require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext().TraceID().String())
+	})
+}
@@ -0,0 +1,303 @@
+package watcher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	internalApp "github.com/spendmail/s3_previewer/internal/app"
+	internalConfig "github.com/spendmail/s3_previewer/internal/config"
+	internalS3 "github.com/spendmail/s3_previewer/internal/s3"
+)
+
+const (
+	defaultInterval = time.Minute
+	defaultOverlap  = 5 * time.Minute
+)
+
+var (
+	ErrCheckpointRead  = errors.New("unable to read watcher checkpoint")
+	ErrCheckpointWrite = errors.New("unable to write watcher checkpoint")
+)
+
+type Config interface {
+	GetWatcherInterval() time.Duration
+	GetWatcherOverlap() time.Duration
+	GetWatcherCheckpoint() string
+	GetWatcherPresets() []string
+	GetWatcherPrefixes() []internalConfig.WatcherPrefixConf
+	GetPresets() map[string]internalConfig.PresetConf
+}
+
+type Logger interface {
+	Debug(args ...interface{})
+	Info(args ...interface{})
+	Warn(args ...interface{})
+	Error(args ...interface{})
+}
+
+type S3Client interface {
+	ListObjects(ctx context.Context, bucket, prefix string) ([]internalS3.ObjectSummary, error)
+}
+
+type Application interface {
+	PurgeObject(ctx context.Context, bucket, key string) int
+	StartWarmup(ctx context.Context, request internalApp.WarmupRequest) (internalApp.WarmupJob, error)
+	GetWarmupJob(id string) (internalApp.WarmupJob, bool)
+}
+
+// prefixState is a checkpoint of a prefix: the latest modification time seen,
+// and ETags of objects modified within overlap before it.
+type prefixState struct {
+	Watermark time.Time         `json:"watermark"`
+	Recent    map[string]string `json:"recent"`
+}
+
+// Watcher polls bucket prefixes and renders presets of new and modified objects.
+// Objects which exist when a prefix is polled for the first time are not rendered.
+type Watcher struct {
+	logger     Logger
+	s3Client   S3Client
+	app        Application
+	interval   time.Duration
+	overlap    time.Duration
+	checkpoint string
+	presets    []string
+	prefixes   []internalConfig.WatcherPrefixConf
+	states     map[string]*prefixState
+	// job is an ID of the latest warm-up job started by the watcher.
+	job string
+}
+
+// New is a watcher constructor. It restores the checkpoint saved by previous runs.
+func New(config Config, logger Logger, s3Client S3Client, app Application) (*Watcher, error) {
+	w := &Watcher{
+		logger:     logger,
+		s3Client:   s3Client,
+		app:        app,
+		interval:   config.GetWatcherInterval(),
+		overlap:    config.GetWatcherOverlap(),
+		checkpoint: config.GetWatcherCheckpoint(),
+		presets:    config.GetWatcherPresets(),
+		prefixes:   config.GetWatcherPrefixes(),
+		states:     make(map[string]*prefixState),
+	}
+
+	if w.interval <= 0 {
+		w.interval = defaultInterval
+	}
+
+	if w.overlap <= 0 {
+		w.overlap = defaultOverlap
+	}
+
+	if len(w.presets) == 0 {
+		for name := range config.GetPresets() {
+			w.presets = append(w.presets, name)
+		}
+		// Presets are rendered in the same order every time.
+		sort.Strings(w.presets)
+	}
+
+	if err := w.load(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// Run polls the prefixes until context is done.
+func (w *Watcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		w.poll(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll lists every prefix once, renders changed objects in a single job and saves the checkpoint.
+// Poll is skipped while the previous job is running. Checkpoints of changed prefixes are advanced
+// only once the job is started, so the changed objects are found again by the next poll otherwise.
+func (w *Watcher) poll(ctx context.Context) {
+	if job, exists := w.app.GetWarmupJob(w.job); exists && job.Status == internalApp.WarmupStatusRunning {
+		w.logger.Debug(fmt.Sprintf("warm-up job %s is still running, poll is skipped", job.ID))
+		return
+	}
+
+	request := internalApp.WarmupRequest{}
+	pending := make(map[string]*prefixState)
+	changedObjects := 0
+
+	for _, prefix := range w.prefixes {
+		if ctx.Err() != nil {
+			return
+		}
+
+		objects, err := w.s3Client.ListObjects(ctx, prefix.Bucket, prefix.Prefix)
+		if err != nil {
+			w.logger.Error(err)
+			continue
+		}
+
+		id := prefix.Bucket + "/" + prefix.Prefix
+		state, known := w.states[id]
+		changed, next := w.diff(state, objects)
+
+		// Prefix polled for the first time only gets its checkpoint.
+		if !known || len(changed) == 0 {
+			w.states[id] = next
+			continue
+		}
+
+		pending[id] = next
+		changedObjects += len(changed)
+		request.Targets = append(request.Targets, w.targets(ctx, prefix.Bucket, changed)...)
+	}
+
+	if len(request.Targets) > 0 {
+		job, err := w.app.StartWarmup(ctx, request)
+		if err != nil {
+			w.logger.Error(err)
+			pending = nil
+		} else {
+			w.job = job.ID
+			w.logger.Info(fmt.Sprintf("%d changed objects found, warm-up job %s is started", changedObjects, job.ID))
+		}
+	}
+
+	for id, next := range pending {
+		w.states[id] = next
+	}
+
+	if err := w.save(); err != nil {
+		w.logger.Error(err)
+	}
+}
+
+// diff returns objects changed since the state, and the state of the listed objects.
+// Objects modified within overlap before the watermark are compared by ETag, as listings could lag behind uploads.
+func (w *Watcher) diff(state *prefixState, objects []internalS3.ObjectSummary) ([]string, *prefixState) {
+	if state == nil {
+		state = &prefixState{}
+	}
+
+	next := &prefixState{Watermark: state.Watermark, Recent: make(map[string]string)}
+	for _, object := range objects {
+		if object.LastModified.After(next.Watermark) {
+			next.Watermark = object.LastModified
+		}
+	}
+
+	threshold := state.Watermark.Add(-w.overlap)
+	nextThreshold := next.Watermark.Add(-w.overlap)
+
+	var changed []string
+	for _, object := range objects {
+		// Keys ending with a slash are folder placeholders rather than images.
+		if strings.HasSuffix(object.Key, "/") {
+			continue
+		}
+
+		if object.LastModified.After(nextThreshold) {
+			next.Recent[object.Key] = object.ETag
+		}
+
+		if !object.LastModified.After(threshold) {
+			continue
+		}
+
+		if etag, seen := state.Recent[object.Key]; seen && etag == object.ETag {
+			continue
+		}
+
+		changed = append(changed, object.Key)
+	}
+
+	return changed, next
+}
+
+// targets purges variants of the changed objects, as they could be overwritten, and returns their presets to be rendered.
+func (w *Watcher) targets(ctx context.Context, bucket string, keys []string) []internalApp.WarmupTarget {
+	var targets []internalApp.WarmupTarget
+	for _, key := range keys {
+		w.app.PurgeObject(ctx, bucket, key)
+
+		for _, preset := range w.presets {
+			targets = append(targets, internalApp.WarmupTarget{Bucket: bucket, Key: key, Preset: preset})
+		}
+	}
+
+	return targets
+}
+
+// load restores the checkpoint. Missing checkpoint means that the prefixes have never been polled.
+func (w *Watcher) load() error {
+	if w.checkpoint == "" {
+		return nil
+	}
+
+	content, err := os.ReadFile(w.checkpoint)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrCheckpointRead, err)
+	}
+
+	if err := json.Unmarshal(content, &w.states); err != nil {
+		return fmt.Errorf("%w: %s", ErrCheckpointRead, err)
+	}
+
+	return nil
+}
+
+// save writes the checkpoint, replacing the previous one atomically.
+func (w *Watcher) save() error {
+	if w.checkpoint == "" {
+		return nil
+	}
+
+	content, err := json.Marshal(w.states)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrCheckpointWrite, err)
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(w.checkpoint), filepath.Base(w.checkpoint)+".*")
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrCheckpointWrite, err)
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		return fmt.Errorf("%w: %s", ErrCheckpointWrite, err)
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("%w: %s", ErrCheckpointWrite, err)
+	}
+
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("%w: %s", ErrCheckpointWrite, err)
+	}
+
+	if err := os.Rename(file.Name(), w.checkpoint); err != nil {
+		return fmt.Errorf("%w: %s", ErrCheckpointWrite, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,163 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	internalApp "github.com/spendmail/s3_previewer/internal/app"
+	internalConfig "github.com/spendmail/s3_previewer/internal/config"
+	internalS3 "github.com/spendmail/s3_previewer/internal/s3"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeLogger struct{}
+
+func (fakeLogger) Debug(args ...interface{}) {}
+func (fakeLogger) Info(args ...interface{})  {}
+func (fakeLogger) Warn(args ...interface{})  {}
+func (fakeLogger) Error(args ...interface{}) {}
+
+type fakeS3Client struct {
+	objects []internalS3.ObjectSummary
+}
+
+func (c *fakeS3Client) ListObjects(ctx context.Context, bucket, prefix string) ([]internalS3.ObjectSummary, error) {
+	return c.objects, nil
+}
+
+type fakeApp struct {
+	purged  []string
+	targets []internalApp.WarmupTarget
+	status  string
+	err     error
+}
+
+func (a *fakeApp) PurgeObject(ctx context.Context, bucket, key string) int {
+	a.purged = append(a.purged, bucket+"/"+key)
+	return 1
+}
+
+func (a *fakeApp) StartWarmup(ctx context.Context, request internalApp.WarmupRequest) (internalApp.WarmupJob, error) {
+	if a.err != nil {
+		return internalApp.WarmupJob{}, a.err
+	}
+
+	a.targets = append(a.targets, request.Targets...)
+	return internalApp.WarmupJob{ID: "abc", Status: a.status}, nil
+}
+
+func (a *fakeApp) GetWarmupJob(id string) (internalApp.WarmupJob, bool) {
+	if id != "abc" {
+		return internalApp.WarmupJob{}, false
+	}
+
+	return internalApp.WarmupJob{ID: id, Status: a.status}, true
+}
+
+func TestWatcher(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	config := &internalConfig.Config{
+		Watcher: internalConfig.WatcherConf{
+			Overlap:    time.Minute,
+			Checkpoint: filepath.Join(t.TempDir(), "checkpoint.json"),
+			Presets:    []string{"small"},
+			Prefixes:   []internalConfig.WatcherPrefixConf{{Bucket: "images", Prefix: "uploads/"}},
+		},
+	}
+
+	t.Run("changed objects", func(t *testing.T) {
+		s3Client := &fakeS3Client{objects: []internalS3.ObjectSummary{
+			{Key: "uploads/", ETag: "dir", LastModified: now.Add(-time.Hour)},
+			{Key: "uploads/old.jpg", ETag: "old", LastModified: now.Add(-time.Hour)},
+			{Key: "uploads/recent.jpg", ETag: "recent", LastModified: now},
+		}}
+		app := &fakeApp{}
+
+		watcher, err := New(config, fakeLogger{}, s3Client, app)
+		require.NoError(t, err)
+
+		// Existing objects are not rendered.
+		watcher.poll(context.Background())
+		require.Empty(t, app.targets)
+
+		s3Client.objects = append(s3Client.objects,
+			// Uploaded object appears in the listing later than the one modified after it.
+			internalS3.ObjectSummary{Key: "uploads/late.jpg", ETag: "late", LastModified: now.Add(-time.Second)},
+			internalS3.ObjectSummary{Key: "uploads/new.jpg", ETag: "new", LastModified: now.Add(time.Second)},
+		)
+		s3Client.objects[2].ETag = "overwritten"
+
+		watcher.poll(context.Background())
+		require.Equal(t, []string{"images/uploads/recent.jpg", "images/uploads/late.jpg", "images/uploads/new.jpg"}, app.purged)
+		require.Equal(t, []internalApp.WarmupTarget{
+			{Bucket: "images", Key: "uploads/recent.jpg", Preset: "small"},
+			{Bucket: "images", Key: "uploads/late.jpg", Preset: "small"},
+			{Bucket: "images", Key: "uploads/new.jpg", Preset: "small"},
+		}, app.targets)
+
+		// Nothing is changed since the previous poll.
+		watcher.poll(context.Background())
+		require.Len(t, app.targets, 3)
+	})
+
+	t.Run("restored checkpoint", func(t *testing.T) {
+		s3Client := &fakeS3Client{objects: []internalS3.ObjectSummary{
+			{Key: "uploads/old.jpg", ETag: "old", LastModified: now.Add(-time.Hour)},
+			{Key: "uploads/recent.jpg", ETag: "overwritten", LastModified: now},
+			{Key: "uploads/late.jpg", ETag: "late", LastModified: now.Add(-time.Second)},
+			{Key: "uploads/new.jpg", ETag: "new", LastModified: now.Add(time.Second)},
+			{Key: "uploads/restarted.jpg", ETag: "restarted", LastModified: now.Add(time.Minute)},
+		}}
+		app := &fakeApp{}
+
+		watcher, err := New(config, fakeLogger{}, s3Client, app)
+		require.NoError(t, err)
+
+		watcher.poll(context.Background())
+		require.Equal(t, []internalApp.WarmupTarget{
+			{Bucket: "images", Key: "uploads/restarted.jpg", Preset: "small"},
+		}, app.targets)
+	})
+
+	t.Run("failed and running jobs", func(t *testing.T) {
+		config := *config
+		config.Watcher.Checkpoint = filepath.Join(t.TempDir(), "checkpoint.json")
+
+		s3Client := &fakeS3Client{objects: []internalS3.ObjectSummary{
+			{Key: "uploads/old.jpg", ETag: "old", LastModified: now},
+		}}
+		app := &fakeApp{err: errors.New("warm-up is unavailable")}
+
+		watcher, err := New(&config, fakeLogger{}, s3Client, app)
+		require.NoError(t, err)
+		watcher.poll(context.Background())
+
+		s3Client.objects = append(s3Client.objects, internalS3.ObjectSummary{Key: "uploads/new.jpg", ETag: "new", LastModified: now.Add(time.Minute)})
+
+		// Checkpoint isn't advanced when the job isn't started, so the object is found again.
+		watcher.poll(context.Background())
+		require.Empty(t, app.targets)
+
+		restarted, err := New(&config, fakeLogger{}, s3Client, app)
+		require.NoError(t, err)
+		require.True(t, now.Equal(restarted.states["images/uploads/"].Watermark))
+
+		app.err = nil
+		app.status = internalApp.WarmupStatusRunning
+		watcher.poll(context.Background())
+		require.Equal(t, []internalApp.WarmupTarget{{Bucket: "images", Key: "uploads/new.jpg", Preset: "small"}}, app.targets)
+
+		// Objects changed while the job is running are found once it is finished.
+		s3Client.objects = append(s3Client.objects, internalS3.ObjectSummary{Key: "uploads/next.jpg", ETag: "next", LastModified: now.Add(2 * time.Minute)})
+		watcher.poll(context.Background())
+		require.Len(t, app.targets, 1)
+
+		app.status = internalApp.WarmupStatusDone
+		watcher.poll(context.Background())
+		require.Equal(t, internalApp.WarmupTarget{Bucket: "images", Key: "uploads/next.jpg", Preset: "small"}, app.targets[1])
+		require.Len(t, app.targets, 2)
+	})
+}